@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// accessLog writes a line per request to out once it is served, in the
+// Apache combined format followed by the latency, or as JSON.
+type accessLog struct {
+	format string // "combined" or "json"
+	out    io.Writer
+	mu     *sync.Mutex
+	next   http.Handler
+}
+
+func newAccessLog(format string, out io.Writer, next http.Handler) accessLog {
+	return accessLog{format: format, out: out, mu: &sync.Mutex{}, next: next}
+}
+
+// statusRecorder notes the status and size of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(b)
+	s.bytes += int64(n)
+	return n, err
+}
+
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter { return s.ResponseWriter }
+
+func (l accessLog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	begin := time.Now()
+	rec := &statusRecorder{ResponseWriter: w}
+	l.next.ServeHTTP(rec, r)
+	latency := time.Since(begin)
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	var line []byte
+	if l.format == "json" {
+		line, _ = json.Marshal(map[string]interface{}{
+			"time":       begin.UTC().Format(time.RFC3339Nano),
+			"client_ip":  ip,
+			"method":     r.Method,
+			"path":       r.URL.RequestURI(),
+			"proto":      r.Proto,
+			"status":     rec.status,
+			"bytes":      rec.bytes,
+			"latency_ms": float64(latency.Microseconds()) / 1000,
+			"referer":    r.Referer(),
+			"user_agent": r.UserAgent(),
+			"request_id": requestID(r.Context()),
+		})
+		line = append(line, '\n')
+	} else {
+		dash := func(s string) string {
+			if s == "" {
+				return "-"
+			}
+			return s
+		}
+		line = []byte(fmt.Sprintf("%s - - [%s] %q %d %d %q %q %.3f\n",
+			ip, begin.Format("02/Jan/2006:15:04:05 -0700"), r.Method+" "+r.URL.RequestURI()+" "+r.Proto,
+			rec.status, rec.bytes, dash(r.Referer()), dash(r.UserAgent()), latency.Seconds()))
+	}
+
+	l.mu.Lock()
+	l.out.Write(line)
+	l.mu.Unlock()
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// accuWeather needs a location key, resolved with a geoposition search,
+// before it answers for a place. The free tier allows only 50 calls a
+// day, so location keys are cached like geocodes.
+type accuWeather struct {
+	apiKey string
+}
+
+func init() {
+	RegisterProvider("accuWeather", func(pc *providerConfig) (weatherProvider, error) {
+		if pc.apiKey == "" {
+			return nil, errNoAPIKey
+		}
+		return accuWeather{apiKey: pc.apiKey}, nil
+	})
+}
+
+// locationKey resolves coord to AccuWeather's location key.
+func (w accuWeather) locationKey(ctx context.Context, coord Coord) (string, error) {
+	cacheKey := fmt.Sprintf("accuweather:%.2f,%.2f", coord.Lat, coord.Lon)
+	if b, ok := geocodes.store.get(cacheKey); ok {
+		return string(b), nil
+	}
+
+	resp, err := httpGet(ctx, baseURL("accuWeather")+"/locations/v1/cities/geoposition/search?apikey="+w.apiKey+
+		fmt.Sprintf("&q=%.2f,%.2f", coord.Lat, coord.Lon))
+	if err != nil {
+		return "", err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("accuWeather: %s", resp.Status)
+	}
+
+	var d struct {
+		Key string `json:"Key"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&d); err != nil {
+		return "", err
+	}
+	if d.Key == "" {
+		return "", fmt.Errorf("accuWeather: no location at %.2f,%.2f", coord.Lat, coord.Lon)
+	}
+
+	geocodes.store.set(cacheKey, []byte(d.Key), geocodeTTL)
+	return d.Key, nil
+}
+
+func (w accuWeather) temperature(ctx context.Context, loc Location) (float64, error) {
+	var coord Coord
+	if loc.Coord != nil {
+		coord = *loc.Coord
+	} else {
+		var err error
+		if coord, err = locateCoord(ctx, loc.query()); err != nil {
+			return 0, err
+		}
+	}
+
+	celsius, err := w.temperatureAt(ctx, coord)
+	if err != nil {
+		return 0, err
+	}
+
+	logReading(ctx, "accuWeather", loc, celsius)
+	return celsius, nil
+}
+
+func (w accuWeather) temperatureAt(ctx context.Context, coord Coord) (float64, error) {
+	key, err := w.locationKey(ctx, coord)
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := httpGet(ctx, baseURL("accuWeather")+"/currentconditions/v1/"+key+"?apikey="+w.apiKey)
+	if err != nil {
+		return 0, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("accuWeather: %s", resp.Status)
+	}
+
+	var d []struct {
+		Temperature struct {
+			Metric struct {
+				Value float64 `json:"Value"`
+			} `json:"Metric"`
+		} `json:"Temperature"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&d); err != nil {
+		return 0, err
+	}
+	if len(d) == 0 {
+		return 0, fmt.Errorf("accuWeather: no current conditions")
+	}
+	return d[0].Temperature.Metric.Value, nil
+}
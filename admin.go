@@ -0,0 +1,197 @@
+package main
+
+import (
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// disabledProviders holds the providers an operator has pulled out of
+// service at runtime, with when they were disabled. Every fan-out skips
+// them until they are enabled again; the state is lost on restart.
+var disabledProviders = struct {
+	sync.RWMutex
+	since map[string]time.Time
+}{since: map[string]time.Time{}}
+
+func providerDisabled(p interface{}) bool {
+	disabledProviders.RLock()
+	defer disabledProviders.RUnlock()
+	_, off := disabledProviders.since[providerName(p)]
+	return off
+}
+
+// enabledProviders returns the providers in ps that are not disabled.
+func enabledProviders(ps []weatherProvider) []weatherProvider {
+	var out []weatherProvider
+	for _, p := range ps {
+		if !providerDisabled(p) {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+type providerState struct {
+	Name     string       `json:"name"`
+	Enabled  bool         `json:"enabled"`
+	Breaker  breakerState `json:"breaker"`
+	Health   healthScore  `json:"health"`
+	Disabled *time.Time   `json:"disabled,omitempty"`
+}
+
+// admin serves the operator API under /admin/:
+//
+//	GET  /admin/providers
+//	POST /admin/providers/{name}/disable
+//	POST /admin/providers/{name}/enable
+//	GET  /admin/experiment
+//	GET  /admin/features
+//	POST /admin/features/{name}/enable[?client=<name>]
+//	POST /admin/features/{name}/disable[?client=<name>]
+//	POST /admin/features/{name}/reset?client=<name>
+//
+// Requests must carry "Authorization: Bearer <token>". Without a
+// configured token the API is off.
+type admin struct {
+	token string
+	mw    multiWeatherProvider
+}
+
+func (a admin) authorized(r *http.Request) bool {
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(got), []byte(a.token)) == 1
+}
+
+func (a admin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if a.token == "" {
+		http.Error(w, "admin API disabled, set admin_token or ADMIN_TOKEN", http.StatusForbidden)
+		return
+	}
+	if !a.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/admin/"), "/")
+	switch {
+	case len(parts) == 1 && parts[0] == "providers":
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		a.list(w, r)
+	case len(parts) == 3 && parts[0] == "providers" && (parts[2] == "enable" || parts[2] == "disable"):
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		a.toggle(w, r, parts[1], parts[2] == "enable")
+	case len(parts) == 1 && parts[0] == "experiment":
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		a.experiment(w, r)
+	case len(parts) == 1 && parts[0] == "features":
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, r, map[string]interface{}{"features": featureStates()})
+	case len(parts) == 3 && parts[0] == "features" && (parts[2] == "enable" || parts[2] == "disable" || parts[2] == "reset"):
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		a.feature(w, r, parts[1], parts[2])
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (a admin) list(w http.ResponseWriter, r *http.Request) {
+	disabledProviders.RLock()
+	defer disabledProviders.RUnlock()
+
+	states := []providerState{}
+	for _, p := range a.mw {
+		s := providerState{Name: providerName(p), Enabled: true, Breaker: breakerFor(providerName(p)).current(), Health: providerScore(providerName(p))}
+		if since, off := disabledProviders.since[s.Name]; off {
+			s.Enabled, s.Disabled = false, &since
+		}
+		states = append(states, s)
+	}
+	writeJSON(w, r, map[string]interface{}{"providers": states})
+}
+
+func (a admin) toggle(w http.ResponseWriter, r *http.Request, name string, enable bool) {
+	found := false
+	for _, p := range a.mw {
+		if providerName(p) == name {
+			found = true
+		}
+	}
+	if !found {
+		http.Error(w, "unknown provider "+name, http.StatusNotFound)
+		return
+	}
+
+	action := "disabled"
+	disabledProviders.Lock()
+	if enable {
+		action = "enabled"
+		delete(disabledProviders.since, name)
+	} else if _, off := disabledProviders.since[name]; !off {
+		disabledProviders.since[name] = time.Now().UTC()
+	}
+	disabledProviders.Unlock()
+
+	slog.InfoContext(r.Context(), "admin", "provider", name, "action", action, "remote", r.RemoteAddr)
+	a.list(w, r)
+}
+
+// experiment reports the outcomes of each arm of the running experiment.
+func (a admin) experiment(w http.ResponseWriter, r *http.Request) {
+	e := activeExperiment
+	if e == nil {
+		http.Error(w, "no experiment is running, start one with -experiment", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, r, map[string]interface{}{
+		"percent": e.percent,
+		"arms":    []armResult{e.arms[0].result(), e.arms[1].result()},
+	})
+}
+
+// feature sets a feature for everyone, or with ?client= for one client;
+// reset drops a client's own setting.
+func (a admin) feature(w http.ResponseWriter, r *http.Request, name, action string) {
+	if !knownFeature(name) {
+		http.Error(w, "unknown feature "+name, http.StatusNotFound)
+		return
+	}
+	client := r.URL.Query().Get("client")
+	if client != "" && !knownClient(client) {
+		http.Error(w, "unknown client "+client, http.StatusNotFound)
+		return
+	}
+
+	switch action {
+	case "reset":
+		if client == "" {
+			http.Error(w, "reset needs ?client=", http.StatusBadRequest)
+			return
+		}
+		resetFeature(client, name)
+	default:
+		setFeature(client, name, action == "enable")
+	}
+
+	slog.InfoContext(r.Context(), "admin", "feature", name, "action", action, "client", client, "remote", r.RemoteAddr)
+	writeJSON(w, r, map[string]interface{}{"features": featureStates()})
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// sourceReading is one provider's answer within an aggregated lookup.
+type sourceReading struct {
+	provider string
+	temp     float64
+	latency  time.Duration
+	err      error
+}
+
+// sourceReport describes one provider's reading in a response.
+type sourceReport struct {
+	Provider string   `json:"provider"`
+	Temp     *float64 `json:"temp,omitempty"`
+	Latency  string   `json:"latency"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// collector is implemented by providers that fan out to several others
+// and can hand back every individual reading, so that the caller decides
+// how to combine them.
+type collector interface {
+	collect(ctx context.Context, loc Location) []sourceReading
+}
+
+// providerFailure is reported in responses for every provider that did
+// not contribute a reading.
+type providerFailure struct {
+	Provider string `json:"provider"`
+	Error    string `json:"error"`
+}
+
+// consensus is the combined result of a set of readings. weights holds
+// the normalized weight of every provider that contributed.
+type consensus struct {
+	temp      float64
+	weights   map[string]float64
+	failed    []providerFailure
+	discarded []discardedReading
+	sources   []sourceReport
+}
+
+// discardedReading is a reading rejected as an outlier.
+type discardedReading struct {
+	Provider string  `json:"provider"`
+	Temp     float64 `json:"temp"`
+	Reason   string  `json:"reason"`
+}
+
+// providerWeights holds the confidence weight of each provider, keyed by
+// provider name. It is filled from the config at startup; providers
+// without an entry weigh 1.
+var providerWeights = map[string]float64{}
+
+func providerWeight(name string) float64 {
+	if w, ok := providerWeights[name]; ok {
+		return w
+	}
+	return 1
+}
+
+// weightedTemp is a successful reading with its provider's weight.
+type weightedTemp struct {
+	temp   float64
+	weight float64
+}
+
+// Aggregator combines the successful readings of a lookup into one
+// temperature, honouring provider weights. values is never empty; when
+// every weight is zero the readings count equally.
+type Aggregator interface {
+	name() string
+	combine(values []weightedTemp) float64
+}
+
+type meanAggregator struct{}
+
+func (meanAggregator) name() string { return "mean" }
+
+func (meanAggregator) combine(values []weightedTemp) float64 {
+	if totalWeight(values) <= 0 {
+		values = evenWeights(values)
+	}
+	sum, total := 0.0, 0.0
+	for _, v := range values {
+		sum += v.temp * v.weight
+		total += v.weight
+	}
+	return sum / total
+}
+
+// medianAggregator takes the weighted median: the reading at which the
+// cumulative weight reaches half of the total. With equal weights this is
+// the ordinary median.
+type medianAggregator struct{}
+
+func (medianAggregator) name() string { return "median" }
+
+func (medianAggregator) combine(values []weightedTemp) float64 {
+	s := sortedCopy(values)
+	total := totalWeight(s)
+	if total <= 0 {
+		s, total = evenWeights(s), float64(len(s))
+	}
+
+	cum := 0.0
+	for i, v := range s {
+		cum += v.weight
+		switch {
+		case math.Abs(cum-total/2) < 1e-9*total && i+1 < len(s):
+			return (v.temp + s[i+1].temp) / 2
+		case cum > total/2:
+			return v.temp
+		}
+	}
+	return s[len(s)-1].temp
+}
+
+// trimmedMeanAggregator drops the given fraction of readings (rounded up)
+// from each end before taking the weighted mean, but always keeps at
+// least one. With three providers it discards the highest and lowest
+// reading.
+type trimmedMeanAggregator struct {
+	fraction float64
+}
+
+func (trimmedMeanAggregator) name() string { return "trimmed-mean" }
+
+func (a trimmedMeanAggregator) combine(values []weightedTemp) float64 {
+	s := sortedCopy(values)
+	k := int(math.Ceil(float64(len(s)) * a.fraction))
+	for len(s)-2*k < 1 {
+		k--
+	}
+	return meanAggregator{}.combine(s[k : len(s)-k])
+}
+
+func totalWeight(values []weightedTemp) float64 {
+	total := 0.0
+	for _, v := range values {
+		total += v.weight
+	}
+	return total
+}
+
+// evenWeights returns a copy of values weighing 1 each.
+func evenWeights(values []weightedTemp) []weightedTemp {
+	even := make([]weightedTemp, len(values))
+	for i, v := range values {
+		even[i] = weightedTemp{v.temp, 1}
+	}
+	return even
+}
+
+func sortedCopy(values []weightedTemp) []weightedTemp {
+	s := append([]weightedTemp(nil), values...)
+	sort.Slice(s, func(i, j int) bool { return s[i].temp < s[j].temp })
+	return s
+}
+
+var aggregators = map[string]Aggregator{
+	"mean":         meanAggregator{},
+	"median":       medianAggregator{},
+	"trimmed-mean": trimmedMeanAggregator{fraction: 0.25},
+}
+
+func parseAggregator(name string) (Aggregator, error) {
+	a, ok := aggregators[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown aggregator %q, want mean, median or trimmed-mean", name)
+	}
+	return a, nil
+}
+
+// policy decides how readings are combined into one temperature.
+type policy struct {
+	// minProviders is how many providers must answer for a result; it
+	// is capped at the number of providers queried.
+	minProviders int
+	aggregator   Aggregator
+
+	// Readings further than outlierDelta degrees from the median, or
+	// more than outlierSigma standard deviations from the mean, are
+	// discarded before aggregation. Zero disables either check.
+	outlierDelta float64
+	outlierSigma float64
+}
+
+// defaultPolicy is the aggregation policy from the config.
+var defaultPolicy = policy{minProviders: 1, aggregator: meanAggregator{}, outlierDelta: 10}
+
+// rejectOutliers splits values into kept and discarded readings. It needs
+// at least three readings: with two there is no telling which is wrong.
+// The readings closest to the median are never discarded, so something
+// is always kept. With an even count the median falls between two
+// readings, and [0, 0, 30, 30] keeps all four.
+func (p policy) rejectOutliers(values []weightedTemp, names []string) ([]weightedTemp, []string, []discardedReading) {
+	if len(values) < 3 || (p.outlierDelta <= 0 && p.outlierSigma <= 0) {
+		return values, names, nil
+	}
+
+	median := medianAggregator{}.combine(evenWeights(values))
+	mean, nearest := 0.0, math.Inf(1)
+	for _, v := range values {
+		mean += v.temp
+		nearest = math.Min(nearest, math.Abs(v.temp-median))
+	}
+	mean /= float64(len(values))
+
+	sd := 0.0
+	for _, v := range values {
+		sd += (v.temp - mean) * (v.temp - mean)
+	}
+	sd = math.Sqrt(sd / float64(len(values)))
+
+	var kept []weightedTemp
+	var keptNames []string
+	var discarded []discardedReading
+	for i, v := range values {
+		switch {
+		case math.Abs(v.temp-median) <= nearest+1e-9:
+			kept = append(kept, v)
+			keptNames = append(keptNames, names[i])
+		case p.outlierDelta > 0 && math.Abs(v.temp-median) > p.outlierDelta:
+			discarded = append(discarded, discardedReading{names[i], v.temp,
+				fmt.Sprintf("%.2f from median %.2f exceeds %.2f", math.Abs(v.temp-median), median, p.outlierDelta)})
+		case p.outlierSigma > 0 && sd > 0 && math.Abs(v.temp-mean) > p.outlierSigma*sd:
+			discarded = append(discarded, discardedReading{names[i], v.temp,
+				fmt.Sprintf("%.1f standard deviations from mean %.2f exceeds %.1f", math.Abs(v.temp-mean)/sd, mean, p.outlierSigma)})
+		default:
+			kept = append(kept, v)
+			keptNames = append(keptNames, names[i])
+		}
+	}
+	return kept, keptNames, discarded
+}
+
+func (p policy) aggregate(readings []sourceReading) (consensus, error) {
+	var c consensus
+	var ok []weightedTemp
+	var names []string
+	for _, r := range readings {
+		src := sourceReport{Provider: r.provider, Latency: r.latency.String()}
+		if r.err != nil {
+			src.Error = publicError(r.err)
+		} else {
+			t := r.temp
+			src.Temp = &t
+		}
+		c.sources = append(c.sources, src)
+
+		if r.err != nil {
+			c.failed = append(c.failed, providerFailure{r.provider, publicError(r.err)})
+			continue
+		}
+		ok = append(ok, weightedTemp{r.temp, providerWeight(r.provider)})
+		names = append(names, r.provider)
+	}
+
+	need := p.minProviders
+	if need > len(readings) || need < 1 {
+		need = len(readings)
+	}
+	if len(ok) < need || len(ok) == 0 {
+		var errs []string
+		for _, f := range c.failed {
+			errs = append(errs, f.Provider+": "+f.Error)
+		}
+		return c, fmt.Errorf("%d of %d providers answered, need %d: %s", len(ok), len(readings), need, strings.Join(errs, "; "))
+	}
+
+	ok, names, c.discarded = p.rejectOutliers(ok, names)
+	if len(ok) == 0 {
+		return c, fmt.Errorf("all %d readings were discarded as outliers", len(c.discarded))
+	}
+
+	total := totalWeight(ok)
+	if total <= 0 {
+		ok, total = evenWeights(ok), float64(len(ok))
+	}
+	c.weights = map[string]float64{}
+	for i, v := range ok {
+		c.weights[names[i]] = v.weight / total
+	}
+
+	c.temp = p.aggregator.combine(ok)
+	return c, nil
+}
+
+// publicError describes a provider error for clients. Upstream URLs can
+// carry API keys, so the URL part of every HTTP client error in err's
+// tree is dropped, however deeply it is wrapped.
+func publicError(err error) string {
+	msg := err.Error()
+	for _, ue := range urlErrors(err) {
+		msg = strings.ReplaceAll(msg, ue.Error(), ue.Err.Error())
+	}
+	return msg
+}
+
+// urlErrors lists the HTTP client errors in err's tree.
+func urlErrors(err error) []*url.Error {
+	switch e := err.(type) {
+	case *url.Error:
+		return []*url.Error{e}
+	case interface{ Unwrap() error }:
+		return urlErrors(e.Unwrap())
+	case interface{ Unwrap() []error }:
+		var all []*url.Error
+		for _, err := range e.Unwrap() {
+			all = append(all, urlErrors(err)...)
+		}
+		return all
+	}
+	return nil
+}
+
+// lookup aggregates p's readings for loc with pol, or simply asks p if
+// it is a single provider.
+func lookup(ctx context.Context, p weatherProvider, loc Location, pol policy) (consensus, error) {
+	if c, ok := p.(collector); ok {
+		return pol.aggregate(c.collect(ctx, loc))
+	}
+	t, err := p.temperature(ctx, loc)
+	return consensus{temp: t}, err
+}
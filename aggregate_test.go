@@ -0,0 +1,51 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func readings(temps ...float64) []sourceReading {
+	var rs []sourceReading
+	for i, t := range temps {
+		rs = append(rs, sourceReading{provider: string(rune('a' + i)), temp: t})
+	}
+	return rs
+}
+
+func TestRejectOutliersKeepsSomething(t *testing.T) {
+	tests := []struct {
+		name  string
+		pol   policy
+		temps []float64
+		want  float64
+		kept  int
+	}{
+		{"even count, delta", policy{aggregator: meanAggregator{}, outlierDelta: 10}, []float64{0, 0, 30, 30}, 15, 4},
+		{"even count, one outlier", policy{aggregator: meanAggregator{}, outlierDelta: 10}, []float64{10, 11, 12, 40}, 11, 3},
+		{"sigma", policy{aggregator: meanAggregator{}, outlierSigma: 0.5}, []float64{0, 0, 30, 30}, 15, 4},
+		{"sigma, odd count", policy{aggregator: meanAggregator{}, outlierSigma: 0.5}, []float64{0, 15, 30}, 15, 1},
+	}
+	for _, tt := range tests {
+		c, err := tt.pol.aggregate(readings(tt.temps...))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if math.IsNaN(c.temp) || math.Abs(c.temp-tt.want) > 1e-9 {
+			t.Errorf("%s: temp %v, want %v", tt.name, c.temp, tt.want)
+		}
+		if kept := len(tt.temps) - len(c.discarded); kept != tt.kept {
+			t.Errorf("%s: kept %d readings, want %d", tt.name, kept, tt.kept)
+		}
+	}
+}
+
+func TestCombineZeroWeights(t *testing.T) {
+	values := []weightedTemp{{10, 0}, {20, 0}, {30, 0}}
+	for name, a := range aggregators {
+		if got := a.combine(values); got != 20 {
+			t.Errorf("%s: %v, want 20", name, got)
+		}
+	}
+}
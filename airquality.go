@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	airQualityTTL      = 30 * time.Minute // OpenWeatherMap updates hourly
+	maxAirQualityCache = 1024
+)
+
+// aqiBreakpoints are the US EPA breakpoints, in the pollutant's own
+// concentration units, for the AQI index ranges 0-50, 51-100, 101-150,
+// 151-200, 201-300 and 301-500.
+var aqiBreakpoints = map[string][]float64{
+	"pm2_5": {0, 9.0, 35.4, 55.4, 125.4, 225.4, 325.4}, // µg/m³, 24h
+	"pm10":  {0, 54, 154, 254, 354, 424, 604},          // µg/m³, 24h
+	"o3":    {0, 54, 70, 85, 105, 200, 604},            // ppb, 8h
+}
+
+var aqiIndexes = []float64{0, 50, 100, 150, 200, 300, 500}
+
+var aqiCategories = []string{
+	"good",
+	"moderate",
+	"unhealthy for sensitive groups",
+	"unhealthy",
+	"very unhealthy",
+	"hazardous",
+}
+
+// aqi returns the EPA index for a concentration of pollutant,
+// interpolating linearly within its breakpoint range and capping at 500.
+func aqi(pollutant string, concentration float64) int {
+	bp := aqiBreakpoints[pollutant]
+	for i := 1; i < len(bp); i++ {
+		if concentration <= bp[i] {
+			lo, hi := bp[i-1], bp[i]
+			frac := (concentration - lo) / (hi - lo)
+			return int(math.Round(aqiIndexes[i-1] + frac*(aqiIndexes[i]-aqiIndexes[i-1])))
+		}
+	}
+	return 500
+}
+
+func aqiCategory(index int) string {
+	for i, limit := range aqiIndexes[1:] {
+		if float64(index) <= limit {
+			return aqiCategories[i]
+		}
+	}
+	return aqiCategories[len(aqiCategories)-1]
+}
+
+// airQualityReport is the body of an /airquality/ response.
+// Concentrations are in µg/m³; AQI is the US EPA index of the worst
+// pollutant.
+type airQualityReport struct {
+	City     string  `json:"city"`
+	Coord    Coord   `json:"coord"`
+	PM25     float64 `json:"pm2_5"`
+	PM10     float64 `json:"pm10"`
+	O3       float64 `json:"o3"`
+	AQI      int     `json:"aqi"`
+	Category string  `json:"category"`
+	Dominant string  `json:"dominant"`
+	Took     string  `json:"took"`
+
+	expires time.Time
+}
+
+// airQuality serves /airquality/{city} from the OpenWeatherMap Air
+// Pollution API. The city is resolved with the same geocoding as
+// /coordinates/ and readings are cached per location for airQualityTTL.
+type airQuality struct {
+	owmKey string
+
+	mu       sync.Mutex
+	readings map[string]airQualityReport
+}
+
+func newAirQuality(owmKey string) *airQuality {
+	return &airQuality{owmKey: owmKey, readings: map[string]airQualityReport{}}
+}
+
+func (a *airQuality) fetch(ctx context.Context, coord Coord) (airQualityReport, error) {
+	resp, err := httpGet(ctx, baseURL("openWeatherMap")+"/data/2.5/air_pollution?lat="+FloatToString(coord.Lat)+"&lon="+FloatToString(coord.Lon)+"&appid="+a.owmKey)
+	if err != nil {
+		return airQualityReport{}, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return airQualityReport{}, fmt.Errorf("air quality upstream: %s", resp.Status)
+	}
+
+	var d struct {
+		List []struct {
+			Components struct {
+				PM25 float64 `json:"pm2_5"`
+				PM10 float64 `json:"pm10"`
+				O3   float64 `json:"o3"`
+			} `json:"components"`
+		} `json:"list"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&d); err != nil {
+		return airQualityReport{}, err
+	}
+	if len(d.List) == 0 {
+		return airQualityReport{}, fmt.Errorf("no air quality data for %s,%s", FloatToString(coord.Lat), FloatToString(coord.Lon))
+	}
+
+	c := d.List[0].Components
+	report := airQualityReport{Coord: coord, PM25: c.PM25, PM10: c.PM10, O3: c.O3}
+
+	// Ozone breakpoints are in ppb; 1 ppb of O3 is about 1.96 µg/m³ at 25 °C.
+	for _, p := range []struct {
+		name  string
+		value float64
+	}{{"pm2_5", c.PM25}, {"pm10", c.PM10}, {"o3", c.O3 / 1.96}} {
+		if i := aqi(p.name, p.value); i > report.AQI || report.Dominant == "" {
+			report.AQI, report.Dominant = i, p.name
+		}
+	}
+	report.Category = aqiCategory(report.AQI)
+	report.expires = time.Now().Add(airQualityTTL)
+	return report, nil
+}
+
+func (a *airQuality) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	begin := time.Now()
+	loc, err := requestLocation(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	city := loc.query()
+
+	if a.owmKey == "" {
+		http.Error(w, "air quality needs an OpenWeatherMap API key", http.StatusNotImplemented)
+		return
+	}
+
+	coord, err := locateCoord(r.Context(), city)
+	if err != nil {
+		http.Error(w, publicError(err), http.StatusInternalServerError)
+		return
+	}
+
+	key := fmt.Sprintf("%.2f,%.2f", coord.Lat, coord.Lon)
+	a.mu.Lock()
+	report, ok := a.readings[key]
+	a.mu.Unlock()
+
+	if !ok || time.Now().After(report.expires) {
+		if report, err = a.fetch(r.Context(), coord); err != nil {
+			http.Error(w, publicError(err), http.StatusBadGateway)
+			return
+		}
+
+		a.mu.Lock()
+		if len(a.readings) >= maxAirQualityCache {
+			a.readings = map[string]airQualityReport{}
+		}
+		a.readings[key] = report
+		a.mu.Unlock()
+	}
+
+	report.City = city
+	report.Took = time.Since(begin).String()
+	writeJSON(w, r, report)
+}
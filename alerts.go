@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// weatherAlert is a severe weather warning in effect at a location.
+// Severity follows the CAP scale: minor, moderate, severe, extreme or
+// unknown.
+type weatherAlert struct {
+	Source      string    `json:"source"`
+	Type        string    `json:"type"`
+	Severity    string    `json:"severity"`
+	Onset       time.Time `json:"onset"`
+	Expires     time.Time `json:"expires"`
+	Description string    `json:"description,omitempty"`
+	Summary     string    `json:"summary,omitempty"` // in the language of the request
+}
+
+var severityRank = map[string]int{"extreme": 4, "severe": 3, "moderate": 2, "minor": 1}
+
+// unixTime is time.Unix for feeds that use 0 for "not given".
+func unixTime(sec int64) time.Time {
+	if sec == 0 {
+		return time.Time{}
+	}
+	return time.Unix(sec, 0).UTC()
+}
+
+// alerter is implemented by providers that publish weather warnings.
+type alerter interface {
+	alerts(ctx context.Context, coord Coord) ([]weatherAlert, error)
+}
+
+// nationalWeatherService is the US National Weather Service. It needs no
+// key and only covers the United States: elsewhere it returns no alerts
+// and, as a weatherProvider, errNotCovered.
+type nationalWeatherService struct{}
+
+func (nationalWeatherService) alerts(ctx context.Context, coord Coord) ([]weatherAlert, error) {
+	resp, err := httpGet(ctx, baseURL("nationalWeatherService")+"/alerts/active?point="+FloatToString(coord.Lat)+","+FloatToString(coord.Lon))
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	// Points outside the US are answered with 400.
+	if resp.StatusCode == http.StatusBadRequest {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("nationalWeatherService: %s", resp.Status)
+	}
+
+	var d struct {
+		Features []struct {
+			Properties struct {
+				Event       string    `json:"event"`
+				Severity    string    `json:"severity"`
+				Onset       time.Time `json:"onset"`
+				Expires     time.Time `json:"expires"`
+				Ends        time.Time `json:"ends"`
+				Headline    string    `json:"headline"`
+				Description string    `json:"description"`
+			} `json:"properties"`
+		} `json:"features"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&d); err != nil {
+		return nil, err
+	}
+
+	var out []weatherAlert
+	for _, f := range d.Features {
+		p := f.Properties
+		// expires is when the message lapses; ends, when set, is when
+		// the hazard does.
+		expires := p.Expires
+		if !p.Ends.IsZero() {
+			expires = p.Ends
+		}
+		out = append(out, weatherAlert{
+			Source:      "nationalWeatherService",
+			Type:        p.Event,
+			Severity:    strings.ToLower(p.Severity),
+			Onset:       p.Onset,
+			Expires:     expires,
+			Description: p.Headline,
+		})
+	}
+	return out, nil
+}
+
+type alertsReport struct {
+	City   string            `json:"city"`
+	Coord  Coord             `json:"coord"`
+	Alerts []weatherAlert    `json:"alerts"`
+	Failed []providerFailure `json:"failed,omitempty"`
+	Took   string            `json:"took"`
+}
+
+// alerts serves /alerts/{city}: the warnings in effect at the city's
+// coordinates from every provider in mw that implements alerter and from
+// the keyless government feeds, most severe first.
+func alerts(mw multiWeatherProvider) http.HandlerFunc {
+	sources := []alerter{nationalWeatherService{}}
+	for _, p := range mw {
+		if _, ok := p.(nationalWeatherService); ok {
+			continue
+		}
+		if a, ok := p.(alerter); ok {
+			sources = append(sources, a)
+		}
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		begin := time.Now()
+		loc, err := requestLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		city := loc.query()
+
+		coord, err := locateCoord(r.Context(), city)
+		if err != nil {
+			http.Error(w, publicError(err), http.StatusInternalServerError)
+			return
+		}
+
+		report := alertsReport{City: city, Coord: coord, Alerts: []weatherAlert{}}
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		for _, s := range sources {
+			if providerDisabled(s) {
+				continue
+			}
+			wg.Add(1)
+			go func(s alerter) {
+				defer wg.Done()
+				ctx, cancel := providerContext(r.Context(), s)
+				defer cancel()
+
+				found, err := s.alerts(ctx, coord)
+
+				mu.Lock()
+				defer mu.Unlock()
+				if err != nil {
+					report.Failed = append(report.Failed, providerFailure{providerName(s), publicError(err)})
+					return
+				}
+				report.Alerts = append(report.Alerts, found...)
+			}(s)
+		}
+		wg.Wait()
+
+		now := time.Now()
+		active := report.Alerts[:0]
+		for _, a := range report.Alerts {
+			if a.Expires.IsZero() || a.Expires.After(now) {
+				active = append(active, a)
+			}
+		}
+		report.Alerts = active
+		sort.SliceStable(report.Alerts, func(i, j int) bool {
+			a, b := report.Alerts[i], report.Alerts[j]
+			if severityRank[a.Severity] != severityRank[b.Severity] {
+				return severityRank[a.Severity] > severityRank[b.Severity]
+			}
+			return a.Onset.Before(b.Onset)
+		})
+		tr := localize(w, r)
+		for i := range report.Alerts {
+			report.Alerts[i].Summary = tr.alertSummary(report.Alerts[i])
+		}
+
+		report.Took = time.Since(begin).String()
+		writeJSON(w, r, report)
+	}
+}
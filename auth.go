@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// apiClient is a [clients.<name>] section of the config: a client
+// allowed in with its API key, optionally with its own rate limit and
+// feature settings.
+type apiClient struct {
+	name      string
+	key       string
+	rateLimit float64 // 0 means the global rate_limit
+	rateBurst int
+	features  map[string]bool
+}
+
+// publicPath reports whether path is served without authentication: the
+// greeting and health checks, and /admin/, which has its own token.
+func publicPath(path string) bool {
+	return path == "/" || probePath(path) || strings.HasPrefix(path, "/admin/")
+}
+
+// probePath reports whether path is a load-balancer health check, which
+// is neither authenticated nor rate limited.
+func probePath(path string) bool {
+	return path == "/healthz" || path == "/readyz"
+}
+
+// apiKeyAuth admits requests whose X-API-Key header matches a configured
+// client, and public paths. Everything else gets 401.
+type apiKeyAuth struct {
+	keys map[string]*apiClient // by key
+	next http.Handler
+}
+
+func newAPIKeyAuth(clients map[string]*apiClient, next http.Handler) apiKeyAuth {
+	a := apiKeyAuth{keys: map[string]*apiClient{}, next: next}
+	for _, c := range clients {
+		a.keys[c.key] = c
+	}
+	return a
+}
+
+func (a apiKeyAuth) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !publicPath(r.URL.Path) {
+		key := r.Header.Get("X-API-Key")
+		if key == "" {
+			http.Error(w, "missing X-API-Key header", http.StatusUnauthorized)
+			return
+		}
+		if a.keys[key] == nil {
+			http.Error(w, "invalid API key", http.StatusUnauthorized)
+			return
+		}
+	}
+	a.next.ServeHTTP(w, r)
+}
+
+func declareClient(clients map[string]*apiClient, key string, v interface{}) error {
+	name, field, _ := strings.Cut(key, ".")
+	c := clients[name]
+	if c == nil {
+		c = &apiClient{name: name}
+		clients[name] = c
+	}
+
+	var err error
+	switch field {
+	case "":
+		// The [clients.<name>] header itself.
+	case "api_key":
+		c.key, err = configString(v)
+	case "rate_limit":
+		f, isNumber := v.(float64)
+		if !isNumber || f <= 0 {
+			return fmt.Errorf("want a positive number")
+		}
+		c.rateLimit = f
+	case "rate_burst":
+		f, isNumber := v.(float64)
+		if !isNumber || f < 1 {
+			return fmt.Errorf("want a positive number")
+		}
+		c.rateBurst = int(f)
+	case "features":
+		var spec string
+		if spec, err = configString(v); err == nil {
+			c.features, err = parseFeatures(spec)
+		}
+	default:
+		err = fmt.Errorf("unknown setting")
+	}
+	return err
+}
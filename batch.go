@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxBatchCities = 50 // cities answered in one batch request
+	batchWorkers   = 8  // concurrent lookups per batch request
+)
+
+type batchResult struct {
+	City   string         `json:"city"`
+	Report *weatherReport `json:"report,omitempty"`
+	Error  string         `json:"error,omitempty"`
+}
+
+// requestCities reads the cities of a multi-city request: a JSON body of
+// {"cities": [...]} for POST, ?cities=London,Paris for GET. Commas
+// separate cities in the query, so country codes need the POST form.
+// How many cities are allowed is up to the caller.
+func requestCities(w http.ResponseWriter, r *http.Request) ([]string, error) {
+	var cities []string
+	if r.Method == http.MethodPost {
+		var req struct {
+			Cities []string `json:"cities"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+			return nil, err
+		}
+		cities = req.Cities
+	} else if q := r.URL.Query().Get("cities"); q != "" {
+		for _, city := range strings.Split(q, ",") {
+			cities = append(cities, strings.TrimSpace(city))
+		}
+	}
+
+	for _, city := range cities {
+		if err := validCity(city); err != nil {
+			return nil, fmt.Errorf("%q: %v", city, err)
+		}
+	}
+	return cities, nil
+}
+
+// batchCities reads the cities of a batch request with requestCities.
+func batchCities(w http.ResponseWriter, r *http.Request) ([]string, error) {
+	cities, err := requestCities(w, r)
+	if err != nil {
+		return nil, fmt.Errorf("invalid batch: %v", err)
+	}
+	if len(cities) == 0 || len(cities) > maxBatchCities {
+		return nil, fmt.Errorf("a batch needs between 1 and %d cities", maxBatchCities)
+	}
+	return cities, nil
+}
+
+// batchWeather answers /v1/weather/batch with a report per city, in the
+// order asked. Lookups run on batchWorkers goroutines and go through
+// the report cache like single-city requests; a city that fails gets an
+// error entry rather than failing the whole batch.
+func batchWeather(mw weatherProvider, cache *reportCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		begin := time.Now()
+		cities, err := batchCities(w, r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		units, pol, err := reportOptions(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		results := lookupBatch(r.Context(), mw, cache, cities, units, pol)
+		writeJSON(w, r, map[string]interface{}{
+			"units":     units,
+			"temp_unit": units.temperatureUnit(),
+			"results":   results,
+			"took":      time.Since(begin).String(),
+		})
+	}
+}
+
+// reportOptions reads ?units= and ?agg=, which shape a report and so
+// take part in its cache key.
+func reportOptions(r *http.Request) (unitSystem, policy, error) {
+	units, err := parseUnitSystem(r.URL.Query().Get("units"))
+	if err != nil {
+		return units, defaultPolicy, err
+	}
+	pol := defaultPolicy
+	if agg := r.URL.Query().Get("agg"); agg != "" {
+		if pol.aggregator, err = parseAggregator(agg); err != nil {
+			return units, pol, err
+		}
+	}
+	return units, pol, nil
+}
+
+// lookupBatch fetches a report per city on up to batchWorkers
+// goroutines, returning the results in the order of cities.
+func lookupBatch(ctx context.Context, mw weatherProvider, cache *reportCache, cities []string, units unitSystem, pol policy) []batchResult {
+	results := make([]batchResult, len(cities))
+	next := make(chan int)
+	var wg sync.WaitGroup
+
+	for n := 0; n < min(batchWorkers, len(cities)); n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				start := time.Now()
+				results[i] = batchResult{City: cities[i]}
+				loc, err := parseCity(cities[i], "")
+				if err == nil {
+					var report weatherReport
+					if report, _, _, err = fetchReport(ctx, mw, cache, loc, units, pol); err == nil {
+						report.Took = time.Since(start).String()
+						results[i].Report = &report
+					}
+				}
+				if err != nil {
+					results[i].Error = publicError(err)
+				}
+			}
+		}()
+	}
+
+	for i := range cities {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// errCircuitOpen is returned instead of calling a provider whose breaker
+// is open.
+var errCircuitOpen = errors.New("circuit open")
+
+// Breaker settings, from breaker_failures and breaker_cooldown in the
+// config. A threshold of 0 disables the breakers.
+var (
+	breakerThreshold = 5
+	breakerCooldown  = 30 * time.Second
+)
+
+type breakerState string
+
+const (
+	breakerClosed   breakerState = "closed"
+	breakerOpen     breakerState = "open"
+	breakerHalfOpen breakerState = "half-open"
+)
+
+// breaker tracks one provider's upstream calls. It opens after
+// breakerThreshold consecutive failures, fails calls fast while open,
+// and after breakerCooldown lets a single trial call through: success
+// closes it, failure opens it for another cooldown.
+type breaker struct {
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	opened   time.Time
+	trial    bool // a half-open trial call is in flight
+}
+
+// breakers holds a breaker per provider name, created on first use.
+var breakers = struct {
+	sync.Mutex
+	m map[string]*breaker
+}{m: map[string]*breaker{}}
+
+func breakerFor(name string) *breaker {
+	breakers.Lock()
+	defer breakers.Unlock()
+	b := breakers.m[name]
+	if b == nil {
+		b = &breaker{state: breakerClosed}
+		breakers.m[name] = b
+	}
+	return b
+}
+
+func (b *breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.opened) < breakerCooldown {
+			return errCircuitOpen
+		}
+		b.state = breakerHalfOpen
+		b.trial = true
+		return nil
+	case breakerHalfOpen:
+		if b.trial {
+			return errCircuitOpen
+		}
+		b.trial = true
+	}
+	return nil
+}
+
+func (b *breaker) record(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+	if !failed {
+		b.state, b.failures = breakerClosed, 0
+		return
+	}
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= breakerThreshold {
+		b.state, b.opened = breakerOpen, time.Now()
+	}
+}
+
+// release ends a call without a verdict.
+func (b *breaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+}
+
+func (b *breaker) current() breakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// providerKey carries the name of the provider an upstream call is made
+// for, set by providerContext.
+type providerKey struct{}
+
+// guarded performs req, with retries, through the breaker of the
+// provider in ctx, if any. Network errors and 5xx responses that remain
+// after retrying count as one failure; a context cancelled by the caller
+// does not count either way.
+func guarded(ctx context.Context, req *http.Request) (*http.Response, error) {
+	name, _ := ctx.Value(providerKey{}).(string)
+	if name == "" || breakerThreshold == 0 {
+		return upstreamRetry.do(ctx, req)
+	}
+
+	b := breakerFor(name)
+	if err := b.allow(); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	resp, err := upstreamRetry.do(ctx, req)
+	switch {
+	case err != nil && errors.Is(ctx.Err(), context.Canceled):
+		b.release()
+	case err != nil:
+		b.record(true)
+	default:
+		b.record(resp.StatusCode >= 500)
+	}
+	return resp, err
+}
+
+func init() {
+	expvar.Publish("breakers", expvar.Func(func() interface{} {
+		breakers.Lock()
+		defer breakers.Unlock()
+		states := map[string]breakerState{}
+		for name, b := range breakers.m {
+			states[name] = b.current()
+		}
+		return states
+	}))
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	maxCacheEntries = 10000
+	redisTimeout    = time.Second
+	redisKeyPrefix  = "hello_world:"
+)
+
+// Cache stores values for a limited time. It is shared by everything
+// that caches upstream answers, so a shared backend lets every replica
+// reuse them. Backend failures are logged and read as misses: a cache
+// must never fail a request.
+type Cache interface {
+	get(key string) ([]byte, bool)
+	set(key string, value []byte, ttl time.Duration)
+}
+
+// newCache returns the cache backend selected in the config: "memory" or
+// a redis://[:password@]host:port[/db] URL.
+func newCache(spec string) (Cache, error) {
+	if spec == "" || spec == "memory" {
+		return newMemoryCache(), nil
+	}
+
+	u, err := url.Parse(spec)
+	if err != nil || u.Scheme != "redis" || u.Host == "" {
+		return nil, fmt.Errorf("unknown cache %q, want memory or redis://host:port", spec)
+	}
+	c := &redisCache{addr: u.Host}
+	if _, _, err := net.SplitHostPort(u.Host); err != nil {
+		c.addr = net.JoinHostPort(u.Host, "6379")
+	}
+	if u.User != nil {
+		c.password, _ = u.User.Password()
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		if c.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("invalid redis database %q", db)
+		}
+	}
+	return c, nil
+}
+
+type memoryEntry struct {
+	value   []byte
+	expires time.Time
+}
+
+// memoryCache is the in-process backend.
+type memoryCache struct {
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+}
+
+func newMemoryCache() *memoryCache {
+	return &memoryCache{entries: map[string]memoryEntry{}}
+}
+
+func (c *memoryCache) get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok || time.Now().After(e.expires) {
+		return nil, false
+	}
+	return e.value, true
+}
+
+func (c *memoryCache) set(key string, value []byte, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.entries) >= maxCacheEntries {
+		now := time.Now()
+		for k, e := range c.entries {
+			if now.After(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= maxCacheEntries {
+			c.entries = map[string]memoryEntry{}
+		}
+	}
+	c.entries[key] = memoryEntry{value: value, expires: time.Now().Add(ttl)}
+}
+
+// redisCache is a Redis backend speaking just enough RESP for GET and
+// SET over a single lazily dialled connection.
+type redisCache struct {
+	addr     string
+	password string
+	db       int
+
+	mu   sync.Mutex
+	conn net.Conn
+	rd   *bufio.Reader
+}
+
+func (c *redisCache) get(key string) ([]byte, bool) {
+	v, err := c.do("GET", redisKeyPrefix+key)
+	if err != nil {
+		slog.Warn("redis", "op", "GET", "key", key, "error", err)
+		return nil, false
+	}
+	b, ok := v.([]byte)
+	return b, ok
+}
+
+func (c *redisCache) set(key string, value []byte, ttl time.Duration) {
+	ms := strconv.FormatInt(ttl.Milliseconds(), 10)
+	if _, err := c.do("SET", redisKeyPrefix+key, string(value), "PX", ms); err != nil {
+		slog.Warn("redis", "op", "SET", "key", key, "error", err)
+	}
+}
+
+// do sends one command and reads its reply. Any error drops the
+// connection so the next command starts afresh.
+func (c *redisCache) do(args ...string) (interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		if err := c.dial(); err != nil {
+			return nil, err
+		}
+	}
+
+	v, err := c.roundTrip(args)
+	if err != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+	return v, err
+}
+
+func (c *redisCache) dial() error {
+	conn, err := net.DialTimeout("tcp", c.addr, redisTimeout)
+	if err != nil {
+		return err
+	}
+	c.conn, c.rd = conn, bufio.NewReader(conn)
+
+	var setup [][]string
+	if c.password != "" {
+		setup = append(setup, []string{"AUTH", c.password})
+	}
+	if c.db != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(c.db)})
+	}
+	for _, args := range setup {
+		if _, err := c.roundTrip(args); err != nil {
+			conn.Close()
+			c.conn = nil
+			return fmt.Errorf("%s: %v", args[0], err)
+		}
+	}
+	return nil
+}
+
+func (c *redisCache) roundTrip(args []string) (interface{}, error) {
+	c.conn.SetDeadline(time.Now().Add(redisTimeout))
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if _, err := io.WriteString(c.conn, b.String()); err != nil {
+		return nil, err
+	}
+	return readRESP(c.rd)
+}
+
+// readRESP reads one reply: simple strings and integers as string and
+// int64, bulk strings as []byte, nil bulk strings as nil and arrays as
+// []interface{}. Error replies are returned as errors.
+func readRESP(rd *bufio.Reader) (interface{}, error) {
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, fmt.Errorf("empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, fmt.Errorf("%s", line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(rd, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = readRESP(rd); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("unexpected reply %q", line)
+}
+
+type cachedReport struct {
+	Report weatherReport `json:"report"`
+	Stored time.Time     `json:"stored"`
+}
+
+// reportCache keeps /weather/ reports for ttl so that repeated requests
+// for a city do not each query every upstream. Reports are keyed by
+// city, units and aggregator, since all three change the body. A zero
+// ttl disables caching. Reports are kept for a further staleWindow to be
+// served when every provider fails.
+type reportCache struct {
+	ttl         time.Duration
+	staleWindow time.Duration
+	store       Cache
+
+	lastPut atomic.Int64 // UnixNano of the last report stored
+}
+
+func newReportCache(ttl, staleWindow time.Duration, store Cache) *reportCache {
+	return &reportCache{ttl: ttl, staleWindow: staleWindow, store: store}
+}
+
+func cacheKey(city string, units unitSystem, agg Aggregator) string {
+	return "weather:" + strings.ToLower(city) + "|" + string(units) + "|" + agg.name()
+}
+
+// get returns the report stored under key and when it was stored, if it
+// is still fresh.
+func (c *reportCache) get(key string) (weatherReport, time.Time, bool) {
+	report, stored, ok := c.load(key)
+	if !ok || time.Since(stored) >= c.ttl {
+		return weatherReport{}, time.Time{}, false
+	}
+	return report, stored, true
+}
+
+// stale is like get but also accepts a report that expired less than
+// staleWindow ago.
+func (c *reportCache) stale(key string) (weatherReport, time.Time, bool) {
+	report, stored, ok := c.load(key)
+	if !ok || time.Since(stored) >= c.ttl+c.staleWindow {
+		return weatherReport{}, time.Time{}, false
+	}
+	return report, stored, true
+}
+
+func (c *reportCache) load(key string) (weatherReport, time.Time, bool) {
+	if c.ttl+c.staleWindow <= 0 {
+		return weatherReport{}, time.Time{}, false
+	}
+
+	b, ok := c.store.get(key)
+	if !ok {
+		return weatherReport{}, time.Time{}, false
+	}
+	var e cachedReport
+	if err := json.Unmarshal(b, &e); err != nil {
+		return weatherReport{}, time.Time{}, false
+	}
+	return e.Report, e.Stored, true
+}
+
+// warm reports whether a report stored by this process is still fresh.
+func (c *reportCache) warm() bool {
+	last := c.lastPut.Load()
+	return last != 0 && time.Since(time.Unix(0, last)) < c.ttl
+}
+
+func (c *reportCache) put(key string, report weatherReport, stored time.Time) {
+	if c.ttl+c.staleWindow <= 0 {
+		return
+	}
+	c.lastPut.Store(stored.UnixNano())
+
+	b, err := json.Marshal(cachedReport{Report: report, Stored: stored})
+	if err != nil {
+		return
+	}
+	c.store.set(key, b, c.ttl+c.staleWindow)
+}
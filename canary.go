@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+)
+
+// canary is a provider that takes part in the aggregation for only a
+// percentage of requests.
+type canary struct {
+	provider weatherProvider
+	percent  float64
+}
+
+// includes decides deterministically whether the canary serves a lookup,
+// by hashing the provider name together with the city into one of 10000
+// buckets. The same city keeps getting the same answer, so a rollout does
+// not make one city's temperature flap between requests.
+func (c canary) includes(city string) bool {
+	h := fnv.New32a()
+	h.Write([]byte(providerName(c.provider) + "\x00" + city))
+	return float64(h.Sum32()%10000) < c.percent*100
+}
+
+// canaryRollout aggregates the stable providers plus whichever canaries
+// include the request.
+type canaryRollout struct {
+	stable   multiWeatherProvider
+	canaries []canary
+}
+
+// newCanaryRollout splits mw according to spec, a comma-separated list of
+// name:percent pairs such as "pirateWeather:10".
+func newCanaryRollout(mw multiWeatherProvider, spec string) (canaryRollout, error) {
+	percents := map[string]float64{}
+	var names []string
+	for _, part := range strings.Split(spec, ",") {
+		i := strings.LastIndex(part, ":")
+		if i < 0 {
+			return canaryRollout{}, fmt.Errorf("invalid canary %q, want name:percent", part)
+		}
+		pct, err := strconv.ParseFloat(part[i+1:], 64)
+		if err != nil || pct < 0 || pct > 100 {
+			return canaryRollout{}, fmt.Errorf("invalid canary percentage in %q", part)
+		}
+		percents[part[:i]] = pct
+		names = append(names, part[:i])
+	}
+
+	named, stable, err := partitionProviders(mw, strings.Join(names, ","))
+	if err != nil {
+		return canaryRollout{}, err
+	}
+	if len(stable) == 0 {
+		return canaryRollout{}, fmt.Errorf("canary rollout leaves no stable provider")
+	}
+
+	c := canaryRollout{stable: stable}
+	for _, p := range named {
+		c.canaries = append(c.canaries, canary{provider: p, percent: percents[providerName(p)]})
+	}
+	return c, nil
+}
+
+func (c canaryRollout) providersFor(loc Location) multiWeatherProvider {
+	set := append(multiWeatherProvider{}, c.stable...)
+	for _, k := range c.canaries {
+		if k.includes(loc.String()) {
+			set = append(set, k.provider)
+		}
+	}
+	return set
+}
+
+func (c canaryRollout) collect(ctx context.Context, loc Location) []sourceReading {
+	return c.providersFor(loc).collect(ctx, loc)
+}
+
+func (c canaryRollout) temperature(ctx context.Context, loc Location) (float64, error) {
+	return c.providersFor(loc).temperature(ctx, loc)
+}
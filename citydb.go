@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// cityRecord is one row of a city database.
+type cityRecord struct {
+	place
+	population int64
+}
+
+// cityDatabase geocodes from a GeoNames cities file (cities500.txt,
+// cities15000.txt, ...) loaded at startup, so lookups need no network.
+// Names match case-insensitively on the name and its ASCII form; among
+// several cities with one name the most populous wins.
+type cityDatabase struct {
+	byName map[string][]cityRecord
+	all    []cityRecord
+}
+
+// loadCityDatabase reads a GeoNames tab-separated cities file.
+func loadCityDatabase(path string) (*cityDatabase, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	db := &cityDatabase{byName: map[string][]cityRecord{}}
+	s := bufio.NewScanner(f)
+	s.Buffer(make([]byte, 64*1024), 1024*1024) // alternate names run long
+	for line := 1; s.Scan(); line++ {
+		cols := strings.Split(s.Text(), "\t")
+		if len(cols) < 15 {
+			return nil, fmt.Errorf("line %d: want GeoNames columns, got %d fields", line, len(cols))
+		}
+		lat, errLat := strconv.ParseFloat(cols[4], 64)
+		lon, errLon := strconv.ParseFloat(cols[5], 64)
+		if errLat != nil || errLon != nil {
+			return nil, fmt.Errorf("line %d: bad coordinates", line)
+		}
+		pop, _ := strconv.ParseInt(cols[14], 10, 64)
+
+		r := cityRecord{place{City: cols[1], Country: cols[8], Coord: Coord{lon, lat}}, pop}
+		db.all = append(db.all, r)
+		name := strings.ToLower(cols[1])
+		db.byName[name] = append(db.byName[name], r)
+		if ascii := strings.ToLower(cols[2]); ascii != name {
+			db.byName[ascii] = append(db.byName[ascii], r)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if len(db.all) == 0 {
+		return nil, fmt.Errorf("no cities")
+	}
+	return db, nil
+}
+
+func (db *cityDatabase) geocode(ctx context.Context, query string) (place, error) {
+	name, cc, _ := strings.Cut(query, ",")
+	best, found := cityRecord{}, false
+	for _, r := range db.byName[strings.ToLower(strings.TrimSpace(name))] {
+		if cc != "" && !strings.EqualFold(r.Country, cc) {
+			continue
+		}
+		if !found || r.population > best.population {
+			best, found = r, true
+		}
+	}
+	if !found {
+		return place{}, fmt.Errorf("cityDatabase: no match")
+	}
+	return best.place, nil
+}
+
+// reverse returns the nearest city by great-circle distance. A linear
+// scan is fast enough for the GeoNames files, which hold tens of
+// thousands of cities.
+func (db *cityDatabase) reverse(ctx context.Context, coord Coord) (place, error) {
+	best, bestDist := -1, math.Inf(1)
+	for i, r := range db.all {
+		if d := distance(coord, r.Coord); d < bestDist {
+			best, bestDist = i, d
+		}
+	}
+	return db.all[best].place, nil
+}
+
+// distance returns the great-circle distance between a and b in
+// kilometres.
+func distance(a, b Coord) float64 {
+	const earthRadius = 6371
+	rad := math.Pi / 180
+	dLat := (b.Lat - a.Lat) * rad
+	dLon := (b.Lon - a.Lon) * rad
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(a.Lat*rad)*math.Cos(b.Lat*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(h))
+}
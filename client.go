@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"time"
+)
+
+// clientConfig tunes upstreamClient; see the http_* config keys.
+type clientConfig struct {
+	timeout        time.Duration // whole request, including the body
+	idleTimeout    time.Duration
+	keepAlive      time.Duration
+	maxIdlePerHost int
+}
+
+var defaultClientConfig = clientConfig{
+	timeout:        30 * time.Second,
+	idleTimeout:    90 * time.Second,
+	keepAlive:      30 * time.Second,
+	maxIdlePerHost: 16,
+}
+
+// upstreamClient makes every request to upstream APIs. Providers hit the
+// same few hosts over and over, so it keeps more idle connections per
+// host than the default transport's two. Its timeout is a backstop;
+// provider timeouts normally cut requests short first.
+var upstreamClient = newUpstreamClient(defaultClientConfig)
+
+func newUpstreamClient(cc clientConfig) *http.Client {
+	dialer := &net.Dialer{Timeout: 5 * time.Second, KeepAlive: cc.keepAlive}
+	return &http.Client{
+		Timeout: cc.timeout,
+		Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			DialContext:           dialer.DialContext,
+			ForceAttemptHTTP2:     true,
+			MaxIdleConns:          100,
+			MaxIdleConnsPerHost:   cc.maxIdlePerHost,
+			IdleConnTimeout:       cc.idleTimeout,
+			TLSHandshakeTimeout:   5 * time.Second,
+			ExpectContinueTimeout: time.Second,
+			DisableKeepAlives:     cc.keepAlive < 0,
+		},
+	}
+}
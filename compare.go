@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+type comparedCity struct {
+	City    string   `json:"city"`
+	Country string   `json:"country,omitempty"`
+	Temp    *float64 `json:"temp,omitempty"`
+	Delta   *float64 `json:"delta,omitempty"` // Temp minus the first city's
+	Stale   bool     `json:"stale,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+type comparison struct {
+	Units    unitSystem     `json:"units"`
+	TempUnit string         `json:"temp_unit"`
+	Cities   []comparedCity `json:"cities"`
+	Warmest  string         `json:"warmest,omitempty"`
+	Coldest  string         `json:"coldest,omitempty"`
+	Spread   *float64       `json:"spread,omitempty"` // warmest minus coldest
+	Took     string         `json:"took"`
+}
+
+// compare answers /v1/compare?cities=Oslo,Madrid with the cities side by
+// side. Deltas are relative to the first city, so list the one to compare
+// against first; cities that fail are left out of the deltas and of the
+// warmest/coldest summary, and the next city becomes the reference. With
+// ?format=geojson the cities are the features of a FeatureCollection,
+// without the summary; a city that cannot be located has no geometry.
+func compare(mw weatherProvider, cache *reportCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		begin := time.Now()
+		cities, err := requestCities(w, r)
+		if err != nil {
+			http.Error(w, "invalid comparison: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(cities) < 2 || len(cities) > maxBatchCities {
+			http.Error(w, fmt.Sprintf("a comparison needs between 2 and %d cities", maxBatchCities), http.StatusBadRequest)
+			return
+		}
+
+		units, pol, err := reportOptions(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		format, err := parseFormat(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		c := comparison{Units: units, TempUnit: units.temperatureUnit()}
+		var base, warmest, coldest *comparedCity
+		for _, res := range lookupBatch(r.Context(), mw, cache, cities, units, pol) {
+			cc := comparedCity{City: res.City, Error: res.Error}
+			if res.Report != nil {
+				t := res.Report.Temp
+				cc.Country, cc.Temp, cc.Stale = res.Report.Country, &t, res.Report.Stale
+			}
+			c.Cities = append(c.Cities, cc)
+		}
+		for i := range c.Cities {
+			cc := &c.Cities[i]
+			if cc.Temp == nil {
+				continue
+			}
+			if base == nil {
+				base = cc
+			}
+			d := *cc.Temp - *base.Temp
+			cc.Delta = &d
+			if warmest == nil || *cc.Temp > *warmest.Temp {
+				warmest = cc
+			}
+			if coldest == nil || *cc.Temp < *coldest.Temp {
+				coldest = cc
+			}
+		}
+		if warmest != nil {
+			spread := *warmest.Temp - *coldest.Temp
+			c.Warmest, c.Coldest, c.Spread = warmest.City, coldest.City, &spread
+		}
+		c.Took = time.Since(begin).String()
+
+		if format == "geojson" {
+			features := make([]geoFeature, len(c.Cities))
+			for i, cc := range c.Cities {
+				features[i].props = cc
+				if coord, err := locateCoord(r.Context(), cities[i]); err == nil {
+					features[i].coord = &coord
+				}
+			}
+			writeFeatureCollection(w, r, features)
+			return
+		}
+		writeJSON(w, r, c)
+	}
+}
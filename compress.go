@@ -0,0 +1,131 @@
+package main
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// compressible lists the content types worth compressing. Tiles are
+// already compressed PNGs and are passed through.
+var compressible = []string{"application/json", "application/geo+json", "text/"}
+
+var gzipWriters = sync.Pool{New: func() interface{} { return gzip.NewWriter(nil) }}
+
+// negotiateEncoding picks gzip or deflate from an Accept-Encoding header,
+// preferring gzip on a tie, or "" for neither.
+func negotiateEncoding(header string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			q, _ = strconv.ParseFloat(v, 64)
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		if (name == "gzip" || name == "deflate") && (q > bestQ || (q == bestQ && name == "gzip")) && q > 0 {
+			best, bestQ = name, q
+		}
+	}
+	return best
+}
+
+// compressor compresses compressible responses for clients that accept
+// gzip or deflate. Whether to compress is decided when the handler writes
+// the header, from the content type it set.
+type compressor struct {
+	next http.Handler
+}
+
+func (c compressor) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Accept-Encoding")
+	enc := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+	if enc == "" || r.Method == http.MethodHead {
+		c.next.ServeHTTP(w, r)
+		return
+	}
+	cw := &compressWriter{ResponseWriter: w, encoding: enc}
+	defer cw.close()
+	c.next.ServeHTTP(cw, r)
+}
+
+type compressWriter struct {
+	http.ResponseWriter
+	encoding    string
+	w           io.WriteCloser // nil when not compressing
+	wroteHeader bool
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+
+	h := cw.Header()
+	ct := h.Get("Content-Type")
+	ok := status != http.StatusNoContent && status != http.StatusNotModified && h.Get("Content-Encoding") == ""
+	if ok {
+		ok = false
+		for _, t := range compressible {
+			if strings.HasPrefix(ct, t) {
+				ok = true
+			}
+		}
+	}
+	if ok {
+		h.Set("Content-Encoding", cw.encoding)
+		h.Del("Content-Length")
+		if cw.encoding == "gzip" {
+			gz := gzipWriters.Get().(*gzip.Writer)
+			gz.Reset(cw.ResponseWriter)
+			cw.w = gz
+		} else {
+			cw.w, _ = flate.NewWriter(cw.ResponseWriter, flate.DefaultCompression)
+		}
+	}
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if !cw.wroteHeader {
+		if cw.Header().Get("Content-Type") == "" {
+			cw.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.w == nil {
+		return cw.ResponseWriter.Write(b)
+	}
+	return cw.w.Write(b)
+}
+
+// Flush sends what has been compressed so far, so that streamed responses
+// keep streaming.
+func (cw *compressWriter) Flush() {
+	switch w := cw.w.(type) {
+	case *gzip.Writer:
+		w.Flush()
+	case *flate.Writer:
+		w.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (cw *compressWriter) Unwrap() http.ResponseWriter { return cw.ResponseWriter }
+
+func (cw *compressWriter) close() {
+	if cw.w == nil {
+		return
+	}
+	cw.w.Close()
+	if gz, ok := cw.w.(*gzip.Writer); ok {
+		gzipWriters.Put(gz)
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Observation is a snapshot of current conditions. Fields a provider
+// does not report are nil. Internally values are metric: Celsius, percent
+// for humidity and cloud cover, m/s for wind, degrees from north for the
+// direction the wind blows from, hPa for pressure, km for visibility and
+// g/m³ for absolute humidity. The dew point, wet-bulb temperature and
+// absolute humidity are derived, not reported; so are the formatted wind
+// fields next to the raw numbers.
+type Observation struct {
+	Temp             *float64 `json:"temp,omitempty"`
+	Humidity         *float64 `json:"humidity,omitempty"`
+	WindSpeed        *float64 `json:"wind_speed,omitempty"`
+	WindDirection    *float64 `json:"wind_direction,omitempty"`
+	WindCardinal     string   `json:"wind_cardinal,omitempty"` // such as "NNW"
+	Beaufort         *int     `json:"beaufort,omitempty"`
+	BeaufortText     string   `json:"beaufort_text,omitempty"` // such as "gentle breeze"
+	Pressure         *float64 `json:"pressure,omitempty"`
+	CloudCover       *float64 `json:"cloud_cover,omitempty"`
+	Visibility       *float64 `json:"visibility,omitempty"`
+	UVIndex          *float64 `json:"uv_index,omitempty"`
+	UVRisk           string   `json:"uv_risk,omitempty"`
+	DewPoint         *float64 `json:"dew_point,omitempty"`
+	WetBulb          *float64 `json:"wet_bulb,omitempty"`
+	AbsoluteHumidity *float64 `json:"absolute_humidity,omitempty"`
+}
+
+// observer is implemented by providers that report more than the
+// temperature.
+type observer interface {
+	observe(ctx context.Context, city string) (Observation, error)
+}
+
+func ptr(v float64) *float64 {
+	return &v
+}
+
+// uvRisk returns the WHO exposure category for a UV index.
+func uvRisk(index float64) string {
+	switch {
+	case index < 3:
+		return "low"
+	case index < 6:
+		return "moderate"
+	case index < 8:
+		return "high"
+	case index < 11:
+		return "very high"
+	}
+	return "extreme"
+}
+
+// cardinals are the 16 compass points, clockwise from north.
+var cardinals = [16]string{"N", "NNE", "NE", "ENE", "E", "ESE", "SE", "SSE", "S", "SSW", "SW", "WSW", "W", "WNW", "NW", "NNW"}
+
+// windCardinal returns the compass point nearest to a direction in
+// degrees from north.
+func windCardinal(degrees float64) string {
+	i := int(math.Round(math.Mod(degrees, 360)/22.5)) % 16
+	if i < 0 {
+		i += 16
+	}
+	return cardinals[i]
+}
+
+// beaufortScale holds the upper wind speed of each Beaufort force in m/s
+// and its description; anything faster is force 12.
+var beaufortScale = []struct {
+	below float64
+	text  string
+}{
+	{0.5, "calm"},
+	{1.6, "light air"},
+	{3.4, "light breeze"},
+	{5.5, "gentle breeze"},
+	{8.0, "moderate breeze"},
+	{10.8, "fresh breeze"},
+	{13.9, "strong breeze"},
+	{17.2, "near gale"},
+	{20.8, "gale"},
+	{24.5, "strong gale"},
+	{28.5, "storm"},
+	{32.7, "violent storm"},
+}
+
+// beaufort returns the Beaufort force of a wind speed in m/s and its
+// description.
+func beaufort(ms float64) (int, string) {
+	for force, b := range beaufortScale {
+		if ms < b.below {
+			return force, b.text
+		}
+	}
+	return len(beaufortScale), "hurricane force"
+}
+
+// convert returns a copy of o as reported in units. Pressure is always in
+// hPa.
+func (o Observation) convert(units unitSystem) Observation {
+	scale := func(v *float64, f func(float64) float64) *float64 {
+		if v == nil {
+			return nil
+		}
+		return ptr(f(*v))
+	}
+	if o.WindSpeed != nil {
+		force, text := beaufort(*o.WindSpeed)
+		o.Beaufort, o.BeaufortText = &force, text
+	}
+	if o.WindDirection != nil {
+		o.WindCardinal = windCardinal(*o.WindDirection)
+	}
+	o.Temp = scale(o.Temp, units.temperature)
+	o.DewPoint = scale(o.DewPoint, units.temperature)
+	o.WetBulb = scale(o.WetBulb, units.temperature)
+	o.WindSpeed = scale(o.WindSpeed, units.windSpeed)
+	o.Visibility = scale(o.Visibility, units.visibility)
+	if o.UVIndex != nil {
+		o.UVRisk = uvRisk(*o.UVIndex)
+	}
+	return o
+}
+
+type observationSource struct {
+	Provider string       `json:"provider"`
+	Observed *Observation `json:"observed,omitempty"`
+	Error    string       `json:"error,omitempty"`
+}
+
+type conditionsReport struct {
+	City           string              `json:"city"`
+	Conditions     Observation         `json:"conditions"`
+	Units          unitSystem          `json:"units"`
+	TempUnit       string              `json:"temp_unit"`
+	WindUnit       string              `json:"wind_unit"`
+	VisibilityUnit string              `json:"visibility_unit"`
+	Aggregator     string              `json:"aggregator"`
+	Failed         []providerFailure   `json:"failed,omitempty"`
+	Sources        []observationSource `json:"sources"`
+	Took           string              `json:"took"`
+}
+
+// combineObservations merges observations field by field with the
+// policy's aggregator, weighting providers as for temperatures. Outlier
+// rejection is left out: its thresholds are in degrees. Wind direction
+// is a weighted circular mean, so 350° and 10° give 0° rather than 180°.
+func combineObservations(names []string, obs []Observation, pol policy) Observation {
+	field := func(get func(Observation) *float64) *float64 {
+		var values []weightedTemp
+		for i, o := range obs {
+			if v := get(o); v != nil {
+				values = append(values, weightedTemp{*v, providerWeight(names[i])})
+			}
+		}
+		if len(values) == 0 {
+			return nil
+		}
+		return ptr(pol.aggregator.combine(values))
+	}
+
+	var out Observation
+	out.Temp = field(func(o Observation) *float64 { return o.Temp })
+	out.Humidity = field(func(o Observation) *float64 { return o.Humidity })
+	out.WindSpeed = field(func(o Observation) *float64 { return o.WindSpeed })
+	out.Pressure = field(func(o Observation) *float64 { return o.Pressure })
+	out.CloudCover = field(func(o Observation) *float64 { return o.CloudCover })
+	out.Visibility = field(func(o Observation) *float64 { return o.Visibility })
+	out.UVIndex = field(func(o Observation) *float64 { return o.UVIndex })
+
+	var x, y float64
+	for i, o := range obs {
+		if o.WindDirection != nil {
+			rad := *o.WindDirection * math.Pi / 180
+			x += providerWeight(names[i]) * math.Cos(rad)
+			y += providerWeight(names[i]) * math.Sin(rad)
+		}
+	}
+	if x != 0 || y != 0 {
+		out.WindDirection = ptr(math.Mod(math.Atan2(y, x)*180/math.Pi+360, 360))
+	}
+	return out
+}
+
+// conditions serves /conditions/{city} from the providers in mw that
+// implement observer.
+func conditions(mw multiWeatherProvider) http.HandlerFunc {
+	var providers []weatherProvider
+	for _, p := range mw {
+		if _, ok := p.(observer); ok {
+			providers = append(providers, p)
+		}
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		begin := time.Now()
+		loc, err := requestLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		city := loc.query()
+		q := r.URL.Query()
+
+		providers := enabledProviders(providers)
+		if len(providers) == 0 {
+			http.Error(w, "no configured provider reports conditions", http.StatusNotImplemented)
+			return
+		}
+
+		units, err := parseUnitSystem(q.Get("units"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		pol := defaultPolicy
+		if agg := q.Get("agg"); agg != "" {
+			if pol.aggregator, err = parseAggregator(agg); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		obs := make([]Observation, len(providers))
+		errs := make([]error, len(providers))
+		var wg sync.WaitGroup
+		for i, p := range providers {
+			wg.Add(1)
+			go func(i int, p weatherProvider) {
+				defer wg.Done()
+				ctx, cancel := providerContext(r.Context(), p)
+				defer cancel()
+				obs[i], errs[i] = p.(observer).observe(ctx, city)
+			}(i, p)
+		}
+		wg.Wait()
+
+		report := conditionsReport{
+			City:           city,
+			Units:          units,
+			TempUnit:       units.temperatureUnit(),
+			WindUnit:       units.windSpeedUnit(),
+			VisibilityUnit: units.visibilityUnit(),
+			Aggregator:     pol.aggregator.name(),
+		}
+		var names []string
+		var ok []Observation
+		for i, p := range providers {
+			src := observationSource{Provider: providerName(p)}
+			if errs[i] != nil {
+				src.Error = publicError(errs[i])
+				report.Failed = append(report.Failed, providerFailure{src.Provider, src.Error})
+			} else {
+				o := obs[i].convert(units)
+				src.Observed = &o
+				names = append(names, src.Provider)
+				ok = append(ok, obs[i])
+			}
+			report.Sources = append(report.Sources, src)
+		}
+
+		need := pol.minProviders
+		if need > len(providers) || need < 1 {
+			need = len(providers)
+		}
+		if len(ok) < need {
+			http.Error(w, fmt.Sprintf("%d of %d providers answered, need %d", len(ok), len(providers), need), http.StatusInternalServerError)
+			return
+		}
+
+		report.Conditions = combineObservations(names, ok, pol).derive().convert(units)
+		report.Took = time.Since(begin).String()
+		writeJSON(w, r, report)
+	}
+}
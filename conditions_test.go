@@ -0,0 +1,16 @@
+package main
+
+import "testing"
+
+func TestWindFormatting(t *testing.T) {
+	for deg, want := range map[float64]string{0: "N", 11: "N", 12: "NNE", 244: "WSW", 337.5: "NNW", 350: "N", 360: "N", -10: "N", -30: "NNW"} {
+		if got := windCardinal(deg); got != want {
+			t.Errorf("windCardinal(%v) = %s, want %s", deg, got, want)
+		}
+	}
+	for ms, want := range map[float64]int{0: 0, 0.5: 1, 3.6: 3, 20: 8, 40: 12} {
+		if got, _ := beaufort(ms); got != want {
+			t.Errorf("beaufort(%v) = %d, want %d", ms, got, want)
+		}
+	}
+}
@@ -0,0 +1,591 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// providerConfig is the [providers.<name>] section of the config file.
+type providerConfig struct {
+	enabled bool
+	apiKey  string
+	timeout time.Duration // 0 means no limit
+	weight  float64       // confidence weight in the aggregation
+	seed    int64         // syntheticWeather only
+}
+
+// config is the service configuration assembled at startup from the
+// optional -config file and the environment. API keys are never compiled
+// into the binary; environment variables override keys from the file.
+//
+// A config file looks like:
+//
+//	listen = ":8080"        # or "unix:/run/weather.sock"
+//	timeout = "5s"          # default per-provider timeout
+//	min_providers = 2       # providers that must answer, default 1
+//	aggregator = "median"   # mean (default), median or trimmed-mean
+//	outlier_delta = 10      # discard readings this far (°C) from the median, 0 disables
+//	outlier_sigma = 0       # discard readings this many std devs from the mean, 0 disables
+//	cache_ttl = "5m"        # how long /weather/ reports are reused, "0s" disables
+//	stale_window = "1h"     # serve reports this far past cache_ttl when every provider fails
+//	cache = "memory"        # or "redis://[:password@]host:6379[/db]" to share between replicas, which then elect one to refresh watched reports
+//	geocode_file = "geocode.json" # keep city coordinates across restarts
+//	geocoders = ["openWeatherMap", "nominatim"] # tried in order, also "openMeteo"
+//	city_database = "cities15000.txt" # GeoNames file, consulted before the geocoders
+//	breaker_failures = 5    # consecutive upstream failures that open a provider's breaker, 0 disables
+//	breaker_cooldown = "30s" # how long an open breaker fails fast before a trial call
+//	retry_attempts = 2      # tries per upstream request on network errors and 5xx, 1 disables retries
+//	retry_backoff = "200ms" # wait before the first retry, doubling after each
+//	retry_jitter = 0.5      # randomize each wait by up to this fraction
+//	health_min_success = 0.5 # exclude providers below this recent success rate, 0 disables
+//	health_max_p95 = "0s"   # exclude providers slower than this at the 95th percentile, "0s" disables
+//	http_timeout = "30s"    # upper bound on any upstream request
+//	http_max_idle_per_host = 16 # pooled connections kept per upstream host
+//	http_idle_timeout = "90s" # close pooled connections idle this long
+//	http_keep_alive = "30s" # TCP keep-alive period, "-1s" disables keep-alives
+//	rate_limit = 5          # requests per second per client, 0 (default) disables
+//	rate_burst = 20         # requests a client may make at once
+//	auth = "api_key"        # require X-API-Key from a [clients.*] entry, or "jwt"; default "none"
+//	jwt_issuer = "https://id.example.com/"
+//	jwt_audience = "weather"
+//	jwt_jwks_url = "https://id.example.com/.well-known/jwks.json"  # required for auth = "jwt"
+//	cors_origins = ["https://app.example.com"]  # or ["*"]; unset disables CORS
+//	cors_methods = ["GET", "POST"]              # default GET, HEAD, POST
+//	cors_max_age = "10m"    # how long browsers may cache a preflight answer
+//	compress = true         # gzip or deflate JSON and text responses when accepted
+//	access_log = "combined" # log every request to stdout, "combined" or "json"; default "off"
+//	otlp_endpoint = "http://localhost:4318" # export traces over OTLP/HTTP, or OTEL_EXPORTER_OTLP_ENDPOINT
+//	refresh_interval = "1m" # how often reports streamed to subscribers are looked up again
+//	admin_token = "..."     # bearer token for /admin/, or ADMIN_TOKEN; unset disables it
+//
+//	[clients.mobile]
+//	api_key = "..."
+//	rate_limit = 20         # overrides rate_limit and rate_burst for this client
+//	rate_burst = 40
+//	features = "templates=off" # name=on|off settings, overriding -features for this client
+//
+//	[providers.openWeatherMap]
+//	api_key = "..."         # or OPENWEATHERMAP_API_KEY; the provider is disabled without one
+//
+//	[providers.nationalWeatherService] # US only, skipped elsewhere
+//
+//	[providers.pirateWeather] # formerly forecastIo, which is still accepted
+//	api_key = "..."
+//	timeout = "3s"
+//	weight = 0.5            # confidence relative to others, default 1
+//
+// When the file declares any provider, only the declared providers with
+// enabled not set to false are used.
+type config struct {
+	listen       string
+	timeout      time.Duration
+	minProviders int
+	aggregator   Aggregator
+	outlierDelta float64
+	outlierSigma float64
+	cacheTTL     time.Duration
+	staleWindow  time.Duration
+	cache        Cache
+	geocodeFile  string
+	geocoders    []Geocoder
+	cityDB       *cityDatabase
+	adminToken   string
+	breakerFails int
+	breakerCool  time.Duration
+	retry        retryPolicy
+	minSuccess   float64
+	maxP95       time.Duration
+	client       clientConfig
+	rateLimit    float64
+	rateBurst    int
+	auth         string
+	jwt          jwtConfig
+	cors         corsConfig
+	compress     bool
+	accessLog    string
+	otlpEndpoint string
+	refresh      time.Duration
+	clients      map[string]*apiClient
+	providers    map[string]*providerConfig
+}
+
+// providerAliases maps deprecated provider names onto their
+// replacements, for config files, and deprecatedKeyEnv the replacements
+// onto the old environment variable still read for their key.
+var (
+	providerAliases  = map[string]string{"forecastIo": "pirateWeather"}
+	deprecatedKeyEnv = map[string]string{"pirateWeather": "FORECASTIO_API_KEY"}
+)
+
+// providerTimeouts bounds each provider lookup in multiWeatherProvider,
+// keyed by provider name. It is filled from the config at startup.
+var providerTimeouts = map[string]time.Duration{}
+
+func defaultConfig() config {
+	return config{
+		listen:       ":8080",
+		timeout:      10 * time.Second,
+		minProviders: 1,
+		aggregator:   meanAggregator{},
+		outlierDelta: 10,
+		cacheTTL:     5 * time.Minute,
+		refresh:      time.Minute,
+		breakerFails: 5,
+		breakerCool:  30 * time.Second,
+		retry:        retryPolicy{attempts: 2, backoff: 200 * time.Millisecond, jitter: 0.5},
+		minSuccess:   0.5,
+		client:       defaultClientConfig,
+		rateBurst:    20,
+		auth:         "none",
+		compress:     true,
+		accessLog:    "off",
+		cors:         corsConfig{methods: defaultCORSMethods, maxAge: 10 * time.Minute},
+		clients:      map[string]*apiClient{},
+		staleWindow:  time.Hour,
+		cache:        newMemoryCache(),
+		geocoders:    []Geocoder{openWeatherMap{}},
+		providers: map[string]*providerConfig{
+			"openWeatherMap":     {enabled: true, weight: 1},
+			"weatherUnderground": {enabled: true, weight: 1},
+			"pirateWeather":      {enabled: true, weight: 1},
+			"openMeteo":          {enabled: true, weight: 1},
+		},
+	}
+}
+
+// loadConfig reads the config file at path, if any, and applies the
+// environment on top of it.
+func loadConfig(path string) (config, error) {
+	c := defaultConfig()
+
+	if path != "" {
+		if err := c.readFile(path); err != nil {
+			return config{}, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+
+	for _, name := range providerNames() {
+		env := keyEnv(name)
+		key := os.Getenv(env)
+		if old := deprecatedKeyEnv[name]; key == "" && os.Getenv(old) != "" {
+			slog.Warn("deprecated environment variable", "name", old, "use", env)
+			key = os.Getenv(old)
+		}
+		if key != "" {
+			if c.providers[name] == nil {
+				// Keep the key (e.g. for map tiles) without enabling a
+				// provider the config file left out.
+				c.providers[name] = &providerConfig{weight: 1}
+			}
+			c.providers[name].apiKey = key
+		}
+	}
+
+	if token := os.Getenv("ADMIN_TOKEN"); token != "" {
+		c.adminToken = token
+	}
+	if endpoint := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); endpoint != "" {
+		c.otlpEndpoint = endpoint
+	}
+
+	var err error
+	if geocodes, err = newGeocodeCache(c.cache, c.geocodeFile); err != nil {
+		return config{}, fmt.Errorf("%s: %v", c.geocodeFile, err)
+	}
+
+	// The OpenWeatherMap geocoder needs the provider's key and is left
+	// out without one; Open-Meteo stands in when nothing else is left.
+	var gs []Geocoder
+	for _, g := range c.geocoders {
+		if _, ok := g.(openWeatherMap); ok {
+			key := c.apiKey("openWeatherMap")
+			if key == "" {
+				slog.Warn("geocoder disabled", "geocoder", "openWeatherMap", "error", errNoAPIKey)
+				continue
+			}
+			g = openWeatherMap{apiKey: key}
+		}
+		gs = append(gs, g)
+	}
+	if len(gs) == 0 {
+		gs = []Geocoder{openMeteoGeocoder{}}
+	}
+	c.geocoders = gs
+
+	geocoders = c.geocoders
+	if c.cityDB != nil {
+		geocoders = append([]Geocoder{c.cityDB}, geocoders...)
+	}
+
+	breakerThreshold, breakerCooldown = c.breakerFails, c.breakerCool
+	upstreamRetry = c.retry
+	healthMinSuccess, healthMaxP95 = c.minSuccess, c.maxP95
+	upstreamClient = newUpstreamClient(c.client)
+
+	defaultPolicy = policy{
+		minProviders: c.minProviders,
+		aggregator:   c.aggregator,
+		outlierDelta: c.outlierDelta,
+		outlierSigma: c.outlierSigma,
+	}
+	for name, pc := range c.providers {
+		if pc.timeout == 0 {
+			pc.timeout = c.timeout
+		}
+		providerTimeouts[name] = pc.timeout
+		providerWeights[name] = pc.weight
+	}
+
+	return c, nil
+}
+
+func (c *config) readFile(path string) error {
+	switch filepath.Ext(path) {
+	case ".toml":
+	case ".yaml", ".yml":
+		return fmt.Errorf("YAML config files are not supported, use TOML")
+	default:
+		return fmt.Errorf("config file must have a .toml extension")
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	values, err := parseTOML(f)
+	if err != nil {
+		return err
+	}
+
+	declared := map[string]*providerConfig{}
+	var keys []string
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		v := values[k]
+		var err error
+		switch {
+		case k == "listen":
+			c.listen, err = configString(v)
+		case k == "timeout":
+			c.timeout, err = configDuration(v)
+		case k == "cache_ttl":
+			c.cacheTTL, err = configDuration(v)
+		case k == "stale_window":
+			c.staleWindow, err = configDuration(v)
+		case k == "city_database":
+			var path string
+			if path, err = configString(v); err == nil {
+				c.cityDB, err = loadCityDatabase(path)
+			}
+		case k == "geocoders":
+			c.geocoders, err = configGeocoders(v)
+		case k == "breaker_failures":
+			f, isNumber := v.(float64)
+			if !isNumber || f < 0 {
+				err = fmt.Errorf("want a non-negative number")
+			}
+			c.breakerFails = int(f)
+		case k == "breaker_cooldown":
+			c.breakerCool, err = configDuration(v)
+		case k == "retry_attempts":
+			f, isNumber := v.(float64)
+			if !isNumber || f < 1 {
+				err = fmt.Errorf("want a positive number")
+			}
+			c.retry.attempts = int(f)
+		case k == "retry_backoff":
+			c.retry.backoff, err = configDuration(v)
+		case k == "retry_jitter":
+			f, isNumber := v.(float64)
+			if !isNumber || f < 0 || f > 1 {
+				err = fmt.Errorf("want a number between 0 and 1")
+			}
+			c.retry.jitter = f
+		case k == "health_min_success":
+			f, isNumber := v.(float64)
+			if !isNumber || f < 0 || f > 1 {
+				err = fmt.Errorf("want a number between 0 and 1")
+			}
+			c.minSuccess = f
+		case k == "health_max_p95":
+			c.maxP95, err = configDuration(v)
+		case k == "http_timeout":
+			c.client.timeout, err = configDuration(v)
+		case k == "http_idle_timeout":
+			c.client.idleTimeout, err = configDuration(v)
+		case k == "http_keep_alive":
+			c.client.keepAlive, err = configDuration(v)
+		case k == "http_max_idle_per_host":
+			f, isNumber := v.(float64)
+			if !isNumber || f < 1 {
+				err = fmt.Errorf("want a positive number")
+			}
+			c.client.maxIdlePerHost = int(f)
+		case k == "rate_limit":
+			f, isNumber := v.(float64)
+			if !isNumber || f < 0 {
+				err = fmt.Errorf("want a non-negative number")
+			}
+			c.rateLimit = f
+		case k == "rate_burst":
+			f, isNumber := v.(float64)
+			if !isNumber || f < 1 {
+				err = fmt.Errorf("want a positive number")
+			}
+			c.rateBurst = int(f)
+		case k == "auth":
+			if c.auth, err = configString(v); err == nil && c.auth != "none" && c.auth != "api_key" && c.auth != "jwt" {
+				err = fmt.Errorf("want \"none\", \"api_key\" or \"jwt\"")
+			}
+		case k == "jwt_issuer":
+			c.jwt.issuer, err = configString(v)
+		case k == "jwt_audience":
+			c.jwt.audience, err = configString(v)
+		case k == "jwt_jwks_url":
+			c.jwt.jwksURL, err = configString(v)
+		case k == "clients":
+		case strings.HasPrefix(k, "clients."):
+			err = declareClient(c.clients, strings.TrimPrefix(k, "clients."), v)
+		case k == "cors_origins":
+			c.cors.origins, err = configStrings(v)
+		case k == "cors_methods":
+			if c.cors.methods, err = configStrings(v); err == nil {
+				for i, m := range c.cors.methods {
+					c.cors.methods[i] = strings.ToUpper(m)
+				}
+			}
+		case k == "cors_max_age":
+			c.cors.maxAge, err = configDuration(v)
+		case k == "compress":
+			b, isBool := v.(bool)
+			if !isBool {
+				return fmt.Errorf("%s: want true or false", k)
+			}
+			c.compress = b
+		case k == "access_log":
+			if c.accessLog, err = configString(v); err == nil && c.accessLog != "off" && c.accessLog != "combined" && c.accessLog != "json" {
+				err = fmt.Errorf("want \"off\", \"combined\" or \"json\"")
+			}
+		case k == "otlp_endpoint":
+			c.otlpEndpoint, err = configString(v)
+		case k == "refresh_interval":
+			if c.refresh, err = configDuration(v); err == nil && c.refresh <= 0 {
+				err = fmt.Errorf("want a positive duration")
+			}
+		case k == "admin_token":
+			c.adminToken, err = configString(v)
+		case k == "geocode_file":
+			c.geocodeFile, err = configString(v)
+		case k == "cache":
+			var spec string
+			if spec, err = configString(v); err == nil {
+				c.cache, err = newCache(spec)
+			}
+		case k == "aggregator":
+			var name string
+			if name, err = configString(v); err == nil {
+				c.aggregator, err = parseAggregator(name)
+			}
+		case k == "outlier_delta", k == "outlier_sigma":
+			f, isNumber := v.(float64)
+			if !isNumber || f < 0 {
+				err = fmt.Errorf("want a non-negative number")
+			}
+			if k == "outlier_delta" {
+				c.outlierDelta = f
+			} else {
+				c.outlierSigma = f
+			}
+		case k == "min_providers":
+			f, isNumber := v.(float64)
+			if !isNumber || f < 1 {
+				err = fmt.Errorf("want a positive number")
+			}
+			c.minProviders = int(f)
+		case k == "providers":
+		case strings.HasPrefix(k, "providers."):
+			err = declareProvider(declared, strings.TrimPrefix(k, "providers."), v)
+		default:
+			err = fmt.Errorf("unknown setting")
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", k, err)
+		}
+	}
+
+	if len(declared) > 0 {
+		c.providers = declared
+	}
+
+	owners := map[string]string{}
+	for name, cl := range c.clients {
+		if cl.key == "" {
+			return fmt.Errorf("clients.%s: api_key is required", name)
+		}
+		if other, dup := owners[cl.key]; dup {
+			return fmt.Errorf("clients.%s: same api_key as clients.%s", name, other)
+		}
+		owners[cl.key] = name
+		if cl.rateLimit > 0 && cl.rateBurst == 0 {
+			cl.rateBurst = c.rateBurst
+		}
+	}
+	if c.auth == "api_key" && len(c.clients) == 0 {
+		return fmt.Errorf("auth = \"api_key\" needs at least one [clients.<name>] section")
+	}
+	if c.auth == "jwt" && c.jwt.jwksURL == "" {
+		return fmt.Errorf("auth = \"jwt\" needs jwt_jwks_url")
+	}
+	return nil
+}
+
+func declareProvider(declared map[string]*providerConfig, key string, v interface{}) error {
+	name, field, _ := strings.Cut(key, ".")
+	if to, ok := providerAliases[name]; ok {
+		if field == "" {
+			slog.Warn("deprecated provider name", "provider", name, "use", to)
+		}
+		name = to
+	}
+	if !isProviderName(name) {
+		return fmt.Errorf("unknown provider %q", name)
+	}
+
+	pc := declared[name]
+	if pc == nil {
+		pc = &providerConfig{enabled: true, weight: 1}
+		declared[name] = pc
+	}
+
+	var err error
+	switch field {
+	case "":
+		// The [providers.<name>] header itself.
+	case "enabled":
+		b, isBool := v.(bool)
+		if !isBool {
+			return fmt.Errorf("want true or false")
+		}
+		pc.enabled = b
+	case "api_key":
+		pc.apiKey, err = configString(v)
+	case "timeout":
+		pc.timeout, err = configDuration(v)
+	case "weight":
+		f, isNumber := v.(float64)
+		if !isNumber || f <= 0 {
+			return fmt.Errorf("want a positive number")
+		}
+		pc.weight = f
+	case "seed":
+		f, isNumber := v.(float64)
+		if !isNumber {
+			return fmt.Errorf("want a number")
+		}
+		pc.seed = int64(f)
+	default:
+		err = fmt.Errorf("unknown setting")
+	}
+	return err
+}
+
+func isProviderName(name string) bool {
+	for _, n := range providerNames() {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func configGeocoders(v interface{}) ([]Geocoder, error) {
+	list, ok := v.([]interface{})
+	if !ok || len(list) == 0 {
+		return nil, fmt.Errorf("want a non-empty list of geocoder names")
+	}
+	var gs []Geocoder
+	for _, e := range list {
+		name, _ := e.(string)
+		g, ok := geocoderNames[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown geocoder %q, want openWeatherMap, nominatim or openMeteo", e)
+		}
+		gs = append(gs, g)
+	}
+	return gs, nil
+}
+
+func configString(v interface{}) (string, error) {
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("want a string")
+	}
+	return s, nil
+}
+
+func configStrings(v interface{}) ([]string, error) {
+	list, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("want a list of strings")
+	}
+	out := make([]string, len(list))
+	for i, e := range list {
+		s, ok := e.(string)
+		if !ok {
+			return nil, fmt.Errorf("want a list of strings")
+		}
+		out[i] = s
+	}
+	return out, nil
+}
+
+func configDuration(v interface{}) (time.Duration, error) {
+	s, err := configString(v)
+	if err != nil {
+		return 0, fmt.Errorf("want a duration string such as \"5s\"")
+	}
+	return time.ParseDuration(s)
+}
+
+// weatherProviders builds the enabled providers that can run with c. A
+// provider whose API key is missing, or whose factory fails, is left out.
+func (c config) weatherProviders() multiWeatherProvider {
+	var mw multiWeatherProvider
+	for _, name := range providerNames() {
+		pc := c.providers[name]
+		if pc == nil || !pc.enabled {
+			continue
+		}
+
+		p, err := providerFactories[name](pc)
+		switch {
+		case errors.Is(err, errNoAPIKey):
+			slog.Warn("provider disabled: no API key, set the variable or api_key in the config file", "provider", name, "env", keyEnv(name))
+		case err != nil:
+			slog.Warn("provider disabled", "provider", name, "error", err)
+		default:
+			mw = append(mw, p)
+		}
+	}
+	return mw
+}
+
+// apiKey returns the configured API key of the named provider.
+func (c config) apiKey(name string) string {
+	if pc := c.providers[name]; pc != nil {
+		return pc.apiKey
+	}
+	return ""
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// corsConfig is the cors_* part of the config. CORS is off while origins
+// is empty; "*" allows any origin.
+type corsConfig struct {
+	origins []string
+	methods []string
+	maxAge  time.Duration
+}
+
+var defaultCORSMethods = []string{"GET", "HEAD", "POST"}
+
+// cors lets browser apps on the configured origins call the API. It
+// answers preflight requests itself, before auth, since browsers send
+// them without credentials.
+type cors struct {
+	cfg  corsConfig
+	next http.Handler
+}
+
+func (c cors) allowed(origin string) bool {
+	for _, o := range c.cfg.origins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c cors) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	w.Header().Add("Vary", "Origin")
+	if origin == "" || !c.allowed(origin) {
+		c.next.ServeHTTP(w, r)
+		return
+	}
+
+	h := w.Header()
+	h.Set("Access-Control-Allow-Origin", origin)
+	if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+		h.Add("Vary", "Access-Control-Request-Method")
+		h.Add("Vary", "Access-Control-Request-Headers")
+		h.Set("Access-Control-Allow-Methods", strings.Join(c.cfg.methods, ", "))
+		h.Set("Access-Control-Allow-Headers", "Authorization, Content-Type, X-API-Key")
+		if c.cfg.maxAge > 0 {
+			h.Set("Access-Control-Max-Age", strconv.Itoa(int(c.cfg.maxAge.Seconds())))
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	h.Set("Access-Control-Expose-Headers", "Retry-After")
+	c.next.ServeHTTP(w, r)
+}
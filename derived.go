@@ -0,0 +1,64 @@
+package main
+
+import "math"
+
+// standardPressure is sea-level pressure in hPa, assumed when an
+// observation has none.
+const standardPressure = 1013.25
+
+// saturationVapourPressure is the vapour pressure of saturated air at
+// celsius, in hPa (Magnus formula, Alduchov and Eskridge coefficients).
+func saturationVapourPressure(celsius float64) float64 {
+	return 6.1094 * math.Exp(17.625*celsius/(celsius+243.04))
+}
+
+// dewPoint is the temperature to which air at celsius and humidity
+// percent relative humidity must cool to saturate.
+func dewPoint(celsius, humidity float64) float64 {
+	g := math.Log(humidity/100) + 17.625*celsius/(celsius+243.04)
+	return 243.04 * g / (17.625 - g)
+}
+
+// wetBulb is the temperature a wetted thermometer settles at, found by
+// bisection on the psychrometric equation at pressure hPa. It lies
+// between the dew point and the air temperature.
+func wetBulb(celsius, humidity, pressure float64) float64 {
+	e := humidity / 100 * saturationVapourPressure(celsius)
+	lo, hi := dewPoint(celsius, humidity), celsius
+	for i := 0; i < 50; i++ {
+		tw := (lo + hi) / 2
+		psy := 0.00066 * (1 + 0.00115*tw) * pressure
+		if saturationVapourPressure(tw)-psy*(celsius-tw) > e {
+			hi = tw
+		} else {
+			lo = tw
+		}
+	}
+	return (lo + hi) / 2
+}
+
+// absoluteHumidity is the mass of water vapour in air at celsius and
+// humidity percent relative humidity, in g/m³.
+func absoluteHumidity(celsius, humidity float64) float64 {
+	e := humidity / 100 * saturationVapourPressure(celsius)
+	return 216.7 * e / (celsius + 273.15)
+}
+
+// derive returns a copy of o with the metrics that follow from its
+// temperature, humidity and pressure filled in. It needs the temperature
+// and humidity; without a pressure, the wet-bulb temperature is taken at
+// sea level. o must be metric, so derive before convert.
+func (o Observation) derive() Observation {
+	if o.Temp == nil || o.Humidity == nil || *o.Humidity <= 0 || *o.Humidity > 100 {
+		return o
+	}
+	t, rh := *o.Temp, *o.Humidity
+	pressure := standardPressure
+	if o.Pressure != nil {
+		pressure = *o.Pressure
+	}
+	o.DewPoint = ptr(dewPoint(t, rh))
+	o.WetBulb = ptr(wetBulb(t, rh, pressure))
+	o.AbsoluteHumidity = ptr(absoluteHumidity(t, rh))
+	return o
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestDerive(t *testing.T) {
+	o := Observation{Temp: ptr(20), Humidity: ptr(50), Pressure: ptr(1013.25)}.derive()
+	for name, c := range map[string]struct {
+		got  *float64
+		want float64
+	}{
+		"dew point":         {o.DewPoint, 9.3},
+		"wet bulb":          {o.WetBulb, 13.8},
+		"absolute humidity": {o.AbsoluteHumidity, 8.6},
+	} {
+		if c.got == nil {
+			t.Errorf("%s: missing", name)
+		} else if math.Abs(*c.got-c.want) > 0.1 {
+			t.Errorf("%s: %.2f, want %.1f", name, *c.got, c.want)
+		}
+	}
+
+	if o := (Observation{Temp: ptr(20)}).derive(); o.DewPoint != nil {
+		t.Errorf("derived a dew point without humidity")
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// schema lists the fields a provider decoder relies on, as dotted paths
+// into the upstream JSON payload mapped to the JSON type expected there
+// ("number", "string", "object", ...). The decoders silently yield zero
+// values when a field moves, so this is what catches upstream changes.
+type schema map[string]string
+
+// contractChecked is implemented by providers whose upstream payload can
+// be validated by the drift checker. contract returns the URL the
+// provider would fetch for city and the schema its decoder expects.
+type contractChecked interface {
+	contract(ctx context.Context, city string) (url string, s schema, err error)
+}
+
+func (w openWeatherMap) contract(ctx context.Context, city string) (string, schema, error) {
+	return w.url(city), schema{
+		"main.temp": "number",
+		"coord.lon": "number",
+		"coord.lat": "number",
+	}, nil
+}
+
+func (w weatherUnderground) contract(ctx context.Context, city string) (string, schema, error) {
+	return w.url(city), schema{"current_observation.temp_c": "number"}, nil
+}
+
+func (w pirateWeather) contract(ctx context.Context, city string) (string, schema, error) {
+	coord, err := locateCoord(ctx, city)
+	if err != nil {
+		return "", nil, err
+	}
+	return w.url(coord), schema{"currently.temperature": "number"}, nil
+}
+
+func (w openMeteo) contract(ctx context.Context, city string) (string, schema, error) {
+	coord, err := locateCoord(ctx, city)
+	if err != nil {
+		return "", nil, err
+	}
+	return w.url(coord, "current=temperature_2m"), schema{"current.temperature_2m": "number"}, nil
+}
+
+func jsonType(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	}
+	return "object"
+}
+
+// validate returns one message per field of s that is missing from doc
+// or has the wrong type.
+func (s schema) validate(doc interface{}) []string {
+	var problems []string
+	for path, want := range s {
+		v, ok := doc, true
+		for _, key := range strings.Split(path, ".") {
+			m, isObject := v.(map[string]interface{})
+			if !isObject {
+				ok = false
+				break
+			}
+			if v, ok = m[key]; !ok {
+				break
+			}
+		}
+
+		if !ok {
+			problems = append(problems, path+" is missing")
+		} else if got := jsonType(v); got != want {
+			problems = append(problems, fmt.Sprintf("%s is %s, want %s", path, got, want))
+		}
+	}
+	return problems
+}
+
+// statusError reports a non-200 upstream response.
+type statusError struct {
+	code   int
+	status string
+}
+
+func (e statusError) Error() string {
+	return "unexpected status " + e.status
+}
+
+func checkContract(ctx context.Context, p contractChecked, city string) error {
+	url, s, err := p.contract(ctx, city)
+	if err != nil {
+		return err
+	}
+
+	resp, err := httpGet(ctx, url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return statusError{resp.StatusCode, resp.Status}
+	}
+
+	var doc interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return err
+	}
+
+	if problems := s.validate(doc); len(problems) > 0 {
+		return fmt.Errorf("schema drift: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// providerName is the name used for a provider in logs and responses.
+func providerName(p interface{}) string {
+	return strings.TrimPrefix(strings.TrimPrefix(fmt.Sprintf("%T", p), "*"), "main.")
+}
+
+// watchContracts checks every provider in mw against its schema using a
+// well-known city, once at start and then every interval, and logs an
+// alert for each provider whose payload no longer matches. In a cluster
+// only the leader checks.
+func watchContracts(mw multiWeatherProvider, city string, interval time.Duration, cl *cluster) {
+	for {
+		for _, p := range mw {
+			if !cl.leader() {
+				break
+			}
+			c, ok := p.(contractChecked)
+			if !ok {
+				continue
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			err := checkContract(ctx, c, city)
+			cancel()
+			if err != nil {
+				slog.Error("contract check failed", "provider", providerName(p), "error", err)
+			}
+		}
+		time.Sleep(interval)
+	}
+}
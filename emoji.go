@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// conditionEmoji maps the condition vocabulary onto emoji for the compact
+// output of ?format=emoji.
+var conditionEmoji = map[string]string{
+	"clear":         "☀️",
+	"partly-cloudy": "⛅",
+	"cloudy":        "☁️",
+	"fog":           "🌫️",
+	"rain":          "🌧️",
+	"sleet":         "🌨️",
+	"snow":          "❄️",
+	"thunderstorm":  "⛈️",
+	"wind":          "💨",
+}
+
+func emojiFor(condition string) string {
+	if e, ok := conditionEmoji[condition]; ok {
+		return e
+	}
+	return "❔"
+}
+
+// wantsEmoji reports whether r asks for the compact emoji output, for
+// chat bots and status bars: with ?format=emoji, or without ?format= by
+// accepting text/plain and not JSON.
+func wantsEmoji(w http.ResponseWriter, r *http.Request) (bool, error) {
+	switch f := r.URL.Query().Get("format"); f {
+	case "emoji":
+		return true, nil
+	case "json":
+		return false, nil
+	case "":
+		w.Header().Add("Vary", "Accept")
+		accept := r.Header.Get("Accept")
+		return strings.Contains(accept, "text/plain") && !strings.Contains(accept, "json"), nil
+	default:
+		return false, fmt.Errorf("unknown format %q, want json or emoji", f)
+	}
+}
+
+// writeEmojiDays writes one line per day: the condition as emoji and in
+// words, then the high and low.
+func writeEmojiDays(w http.ResponseWriter, days []forecastDay, tempUnit string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	for _, d := range days {
+		fmt.Fprintf(w, "%s %s %s %.0f%s / %.0f%s\n", d.Date, emojiFor(d.Condition), d.ConditionText, d.High, tempUnit, d.Low, tempUnit)
+	}
+}
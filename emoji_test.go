@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWantsEmoji(t *testing.T) {
+	tests := []struct {
+		query, accept string
+		want          bool
+		err           bool
+	}{
+		{"", "", false, false},
+		{"format=emoji", "application/json", true, false},
+		{"format=json", "text/plain", false, false},
+		{"", "text/plain", true, false},
+		{"", "text/plain, application/json", false, false},
+		{"format=geojson", "", false, true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/v1/forecast/Oslo?"+tt.query, nil)
+		r.Header.Set("Accept", tt.accept)
+		got, err := wantsEmoji(httptest.NewRecorder(), r)
+		if got != tt.want || (err != nil) != tt.err {
+			t.Errorf("?%s, Accept %q: %v, %v", tt.query, tt.accept, got, err)
+		}
+	}
+}
+
+func TestEmojiForEveryCondition(t *testing.T) {
+	for code := range translations[defaultLanguage].conditions {
+		if code != "unknown" && conditionEmoji[code] == "" {
+			t.Errorf("no emoji for condition %s", code)
+		}
+	}
+}
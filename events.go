@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const sseHeartbeat = 20 * time.Second // comment lines that keep idle streams open
+
+// events serves /v1/weather/{city}/events as Server-Sent Events, for
+// clients that cannot use /v1/stream: a "report" event every time the
+// refresher looks the city up again, and a heartbeat comment in between.
+// Event ids are the time the report was stored, in Unix milliseconds, so
+// a client reconnecting with Last-Event-ID is sent the current report
+// only when it is newer than the last one it saw.
+func events(rf *refresher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		loc, err := parseLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		units, pol, err := reportOptions(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var lastID int64
+		if v := r.Header.Get("Last-Event-ID"); v != "" {
+			if lastID, err = strconv.ParseInt(v, 10, 64); err != nil {
+				http.Error(w, "Last-Event-ID must be an id sent by this endpoint", http.StatusBadRequest)
+				return
+			}
+		}
+
+		rc := http.NewResponseController(w)
+		// The stream outlives any -write-timeout.
+		rc.SetWriteDeadline(time.Time{})
+
+		updates, cancel := rf.subscribe(loc, units, pol)
+		defer cancel()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintf(w, "retry: %d\n\n", rf.interval.Milliseconds())
+		rc.Flush()
+
+		heartbeat := time.NewTicker(sseHeartbeat)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case u := <-updates:
+				id := u.stored.UnixMilli()
+				if id <= lastID {
+					continue
+				}
+				lastID = id
+				report := u.report
+				report.Age = time.Since(u.stored).Round(time.Second).String()
+				data, err := json.Marshal(report)
+				if err != nil {
+					return
+				}
+				fmt.Fprintf(w, "id: %d\nevent: report\ndata: %s\n\n", id, data)
+			case <-heartbeat.C:
+				fmt.Fprint(w, ": heartbeat\n\n")
+			case <-r.Context().Done():
+				return
+			case <-shuttingDown:
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// experiment A/B tests an aggregator against the default one on weather
+// requests that do not pick one with ?agg=. Clients are assigned to an
+// arm by hashing their API key or address, so each keeps seeing the same
+// arm, and every arm records its outcomes for /admin/experiment.
+type experiment struct {
+	percent float64 // of clients in the treatment arm
+	arms    [2]*experimentArm
+}
+
+// experimentArm is one side of an experiment and its outcomes.
+type experimentArm struct {
+	name       string // "control" or "treatment"
+	aggregator Aggregator
+
+	mu        sync.Mutex
+	requests  int
+	errors    int // responses of 400 and above
+	latency   time.Duration
+	agreement float64 // sum of per-report mean distances, in °C
+	reports   int
+}
+
+type armResult struct {
+	Arm        string  `json:"arm"`
+	Aggregator string  `json:"aggregator"`
+	Requests   int     `json:"requests"`
+	Errors     int     `json:"errors"`
+	ErrorRate  float64 `json:"error_rate"`
+	Latency    string  `json:"mean_latency"`
+	// Agreement is the mean distance in °C between the reported
+	// temperature and each provider's reading: lower is closer.
+	Agreement *float64 `json:"mean_agreement,omitempty"`
+}
+
+// activeExperiment is set from -experiment; nil runs none.
+var activeExperiment *experiment
+
+// newExperiment parses spec, an aggregator and the percentage of clients
+// to give it, such as "median:50".
+func newExperiment(spec string) (*experiment, error) {
+	name, pct, ok := strings.Cut(spec, ":")
+	if !ok {
+		return nil, fmt.Errorf("invalid experiment %q, want aggregator:percent", spec)
+	}
+	treatment, err := parseAggregator(name)
+	if err != nil {
+		return nil, err
+	}
+	if treatment.name() == defaultPolicy.aggregator.name() {
+		return nil, fmt.Errorf("experiment: %s is already the default aggregator", name)
+	}
+	percent, err := strconv.ParseFloat(pct, 64)
+	if err != nil || percent <= 0 || percent >= 100 {
+		return nil, fmt.Errorf("invalid experiment percentage in %q, want more than 0 and less than 100", spec)
+	}
+	return &experiment{percent: percent, arms: [2]*experimentArm{
+		{name: "control", aggregator: defaultPolicy.aggregator},
+		{name: "treatment", aggregator: treatment},
+	}}, nil
+}
+
+// assign picks the arm of the client making r.
+func (e *experiment) assign(r *http.Request) *experimentArm {
+	client := r.Header.Get("X-API-Key")
+	if client == "" {
+		client, _, _ = net.SplitHostPort(r.RemoteAddr)
+	}
+	h := fnv.New32a()
+	h.Write([]byte(client))
+	if float64(h.Sum32()%10000) < e.percent*100 {
+		return e.arms[1]
+	}
+	return e.arms[0]
+}
+
+type armKey struct{}
+
+// experimentArmFor returns the arm a request was assigned to, or nil.
+func experimentArmFor(ctx context.Context) *experimentArm {
+	arm, _ := ctx.Value(armKey{}).(*experimentArm)
+	return arm
+}
+
+// withExperiment runs next under the active experiment, if any:
+// requests without ?agg= are assigned an arm, which decides their
+// aggregator, and their status and latency are recorded against it.
+func withExperiment(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e := activeExperiment
+		if e == nil || r.URL.Query().Get("agg") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		arm := e.assign(r)
+		w.Header().Set("X-Experiment-Arm", arm.name)
+
+		begin := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), armKey{}, arm)))
+
+		arm.mu.Lock()
+		arm.requests++
+		arm.latency += time.Since(begin)
+		if rec.status >= 400 {
+			arm.errors++
+		}
+		arm.mu.Unlock()
+	})
+}
+
+// recordAgreement adds how closely report agrees with its providers'
+// readings to the outcomes of the request's arm.
+func recordAgreement(ctx context.Context, report weatherReport) {
+	arm := experimentArmFor(ctx)
+	if arm == nil || report.Stale {
+		return
+	}
+	sum, n := 0.0, 0
+	for _, s := range report.Sources {
+		if s.Temp != nil {
+			sum += math.Abs(*s.Temp - report.Temp)
+			n++
+		}
+	}
+	if n == 0 {
+		return
+	}
+	// Temperature differences scale like the degree: a kelvin is a
+	// Celsius degree, a Fahrenheit degree is 5/9 of one.
+	mean := sum / float64(n)
+	if report.Units == imperial {
+		mean = mean * 5 / 9
+	}
+
+	arm.mu.Lock()
+	arm.agreement += mean
+	arm.reports++
+	arm.mu.Unlock()
+}
+
+func (a *experimentArm) result() armResult {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	r := armResult{Arm: a.name, Aggregator: a.aggregator.name(), Requests: a.requests, Errors: a.errors, Latency: "0s"}
+	if a.requests > 0 {
+		r.ErrorRate = float64(a.errors) / float64(a.requests)
+		r.Latency = (a.latency / time.Duration(a.requests)).String()
+	}
+	if a.reports > 0 {
+		agreement := a.agreement / float64(a.reports)
+		r.Agreement = &agreement
+	}
+	return r
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestExperiment(t *testing.T) {
+	e, err := newExperiment("median:50")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(prev *experiment) { activeExperiment = prev }(activeExperiment)
+	activeExperiment = e
+
+	h := withExperiment(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arm := experimentArmFor(r.Context())
+		if arm == nil {
+			return
+		}
+		a, b := 10.0, 14.0
+		recordAgreement(r.Context(), weatherReport{Temp: 12, Units: metric, Sources: []sourceReport{{Temp: &a}, {Temp: &b}}})
+		if arm.name == "treatment" {
+			http.Error(w, "failed", http.StatusInternalServerError)
+		}
+	}))
+
+	seen := map[string]string{}
+	for _, key := range []string{"a", "b", "c", "d", "e", "f", "g", "h", "a", "b"} {
+		r := httptest.NewRequest("GET", "/v1/weather/Oslo", nil)
+		r.Header.Set("X-API-Key", key)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		arm := rec.Header().Get("X-Experiment-Arm")
+		if prev, ok := seen[key]; ok && prev != arm {
+			t.Errorf("client %s moved from %s to %s", key, prev, arm)
+		}
+		seen[key] = arm
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/v1/weather/Oslo?agg=mean", nil))
+	if rec.Header().Get("X-Experiment-Arm") != "" {
+		t.Errorf("a request choosing its aggregator was put in an arm")
+	}
+
+	control, treatment := e.arms[0].result(), e.arms[1].result()
+	if control.Requests+treatment.Requests != 10 || control.Requests == 0 || treatment.Requests == 0 {
+		t.Fatalf("requests split %d/%d", control.Requests, treatment.Requests)
+	}
+	if control.Errors != 0 || treatment.Errors != treatment.Requests {
+		t.Errorf("errors %d/%d", control.Errors, treatment.Errors)
+	}
+	if control.Agreement == nil || *control.Agreement != 2 {
+		t.Errorf("control agreement %v, want 2", control.Agreement)
+	}
+}
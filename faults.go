@@ -0,0 +1,58 @@
+package main
+
+import (
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// faultInjector delays and fails API responses on purpose so that client
+// teams can exercise their timeout and retry handling. It is only
+// installed in -dev mode. Clients may override the configured values per
+// request with the X-Fault-Delay and X-Fault-Error-Rate headers.
+type faultInjector struct {
+	delay     time.Duration // added to every request
+	jitter    time.Duration // extra random delay in [0, jitter)
+	errorRate float64       // fraction of requests failed, 0..1
+	status    int           // status code of injected failures
+	next      http.Handler
+}
+
+func (f faultInjector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	delay, errorRate := f.delay, f.errorRate
+	if v := r.Header.Get("X-Fault-Delay"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			http.Error(w, "invalid X-Fault-Delay: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		delay = d
+	}
+	if v := r.Header.Get("X-Fault-Error-Rate"); v != "" {
+		rate, err := strconv.ParseFloat(v, 64)
+		if err != nil || rate < 0 || rate > 1 {
+			http.Error(w, "invalid X-Fault-Error-Rate", http.StatusBadRequest)
+			return
+		}
+		errorRate = rate
+	}
+
+	if f.jitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(f.jitter)))
+	}
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	if rand.Float64() < errorRate {
+		http.Error(w, "injected fault", f.status)
+		return
+	}
+
+	f.next.ServeHTTP(w, r)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// features holds the on/off state of risky features so they can be
+// toggled with -features at startup, or through /admin/features at
+// runtime, instead of by a code change. A client may have its own
+// setting for a feature, from the features line of its [clients.<name>]
+// section or from /admin/, which takes precedence for requests with its
+// API key. Every gated feature is registered in on with its default.
+var features = struct {
+	sync.RWMutex
+	on      map[string]bool
+	clients map[string]map[string]bool // overrides by client name
+	keys    map[string]string          // client name by API key
+}{
+	on: map[string]bool{
+		"templates": true, // client-supplied ?template= rendering on /weather/
+	},
+	clients: map[string]map[string]bool{},
+	keys:    map[string]string{},
+}
+
+type featureState struct {
+	Name    string          `json:"name"`
+	Enabled bool            `json:"enabled"`
+	Clients map[string]bool `json:"clients,omitempty"`
+}
+
+// featureEnabled reports whether name is on for the client making r.
+func featureEnabled(r *http.Request, name string) bool {
+	features.RLock()
+	defer features.RUnlock()
+	client := features.keys[r.Header.Get("X-API-Key")]
+	if on, ok := features.clients[client][name]; ok {
+		return on
+	}
+	return features.on[name]
+}
+
+// parseFeatures parses a comma-separated list of name=on|off settings.
+func parseFeatures(spec string) (map[string]bool, error) {
+	settings := map[string]bool{}
+	for _, part := range strings.Split(spec, ",") {
+		name, state, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if !knownFeature(name) {
+			return nil, fmt.Errorf("unknown feature %q, known: %s", name, strings.Join(featureNames(), ", "))
+		}
+
+		switch state {
+		case "on":
+			settings[name] = true
+		case "off":
+			settings[name] = false
+		default:
+			return nil, fmt.Errorf("invalid state %q for feature %s, want on or off", state, name)
+		}
+	}
+	return settings, nil
+}
+
+// setFeatures applies a comma-separated list of name=on|off settings.
+func setFeatures(spec string) error {
+	settings, err := parseFeatures(spec)
+	if err != nil {
+		return err
+	}
+	for name, on := range settings {
+		setFeature("", name, on)
+	}
+	return nil
+}
+
+// setClientFeatures registers the configured clients and their settings.
+func setClientFeatures(clients map[string]*apiClient) {
+	features.Lock()
+	defer features.Unlock()
+	for _, c := range clients {
+		features.keys[c.key] = c.name
+		features.clients[c.name] = map[string]bool{}
+		for name, on := range c.features {
+			features.clients[c.name][name] = on
+		}
+	}
+}
+
+// setFeature turns name on or off for client, or for everyone without a
+// setting of their own when client is "".
+func setFeature(client, name string, on bool) {
+	features.Lock()
+	defer features.Unlock()
+	if client == "" {
+		features.on[name] = on
+	} else {
+		features.clients[client][name] = on
+	}
+}
+
+// resetFeature drops client's own setting for name.
+func resetFeature(client, name string) {
+	features.Lock()
+	defer features.Unlock()
+	delete(features.clients[client], name)
+}
+
+func knownFeature(name string) bool {
+	features.RLock()
+	defer features.RUnlock()
+	_, ok := features.on[name]
+	return ok
+}
+
+func knownClient(client string) bool {
+	features.RLock()
+	defer features.RUnlock()
+	_, ok := features.clients[client]
+	return ok
+}
+
+func featureStates() []featureState {
+	features.RLock()
+	defer features.RUnlock()
+	states := []featureState{}
+	for _, name := range sortedKeys(features.on) {
+		s := featureState{Name: name, Enabled: features.on[name]}
+		for client, settings := range features.clients {
+			if on, ok := settings[name]; ok {
+				if s.Clients == nil {
+					s.Clients = map[string]bool{}
+				}
+				s.Clients[client] = on
+			}
+		}
+		states = append(states, s)
+	}
+	return states
+}
+
+func featureNames() []string {
+	features.RLock()
+	defer features.RUnlock()
+	return sortedKeys(features.on)
+}
+
+func sortedKeys(m map[string]bool) []string {
+	var names []string
+	for n := range m {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
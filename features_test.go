@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFeatureOverrides(t *testing.T) {
+	defer func(on bool) {
+		setFeature("", "templates", on)
+		features.Lock()
+		delete(features.clients, "mobile")
+		delete(features.keys, "m-key")
+		features.Unlock()
+	}(features.on["templates"])
+
+	off, err := parseFeatures("templates=off")
+	if err != nil {
+		t.Fatal(err)
+	}
+	setClientFeatures(map[string]*apiClient{"mobile": {name: "mobile", key: "m-key", features: off}})
+
+	request := func(key string) *http.Request {
+		r := httptest.NewRequest("GET", "/v1/weather/Oslo", nil)
+		r.Header.Set("X-API-Key", key)
+		return r
+	}
+	if !featureEnabled(request(""), "templates") || featureEnabled(request("m-key"), "templates") {
+		t.Fatalf("mobile's setting not honored")
+	}
+
+	a := admin{token: "t"}
+	post := func(path string) int {
+		r := httptest.NewRequest("POST", path, nil)
+		r.Header.Set("Authorization", "Bearer t")
+		rec := httptest.NewRecorder()
+		a.ServeHTTP(rec, r)
+		return rec.Code
+	}
+	if code := post("/admin/features/templates/disable"); code != http.StatusOK {
+		t.Fatalf("disable: %d", code)
+	}
+	if featureEnabled(request(""), "templates") {
+		t.Errorf("templates still on after /admin/ disabled them")
+	}
+	if code := post("/admin/features/templates/enable?client=mobile"); code != http.StatusOK {
+		t.Fatalf("enable for mobile: %d", code)
+	}
+	if !featureEnabled(request("m-key"), "templates") {
+		t.Errorf("templates off for mobile after /admin/ enabled them")
+	}
+	if code := post("/admin/features/templates/reset?client=mobile"); code != http.StatusOK {
+		t.Fatalf("reset: %d", code)
+	}
+	if featureEnabled(request("m-key"), "templates") {
+		t.Errorf("mobile did not fall back to the global setting")
+	}
+
+	for path, want := range map[string]int{
+		"/admin/features/nope/enable":                 http.StatusNotFound,
+		"/admin/features/templates/enable?client=web": http.StatusNotFound,
+		"/admin/features/templates/reset":             http.StatusBadRequest,
+	} {
+		if code := post(path); code != want {
+			t.Errorf("%s: %d, want %d", path, code, want)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+// fixtureName maps an upstream request to the file holding its recorded
+// response body. The full host, path and query are escaped into a single
+// file name so that a fixture directory can be inspected and edited by
+// hand.
+func fixtureName(req *http.Request) string {
+	key := req.URL.Host + req.URL.Path
+	if req.URL.RawQuery != "" {
+		key += "?" + req.URL.RawQuery
+	}
+	return url.QueryEscape(key) + ".json"
+}
+
+// fixtureTransport answers every upstream request from a directory of
+// recorded responses and never touches the network, making the whole API
+// deterministic for demos and integration environments.
+type fixtureTransport struct {
+	dir string
+}
+
+func (t fixtureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := os.ReadFile(filepath.Join(t.dir, fixtureName(req)))
+	if err != nil {
+		return nil, fmt.Errorf("no fixture for %s: %v", req.URL, err)
+	}
+
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"application/json"}},
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// recordingTransport passes requests through to next and saves every
+// successful response body as a fixture for fixtureTransport.
+type recordingTransport struct {
+	dir  string
+	next http.RoundTripper
+}
+
+func (t recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	if err := os.WriteFile(filepath.Join(t.dir, fixtureName(req)), body, 0644); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
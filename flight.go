@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// flightGroup runs one call per key at a time: callers that arrive while
+// a call for their key is in flight wait for it and share its result,
+// instead of starting their own.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flight
+}
+
+type flight struct {
+	done chan struct{}
+	val  interface{}
+}
+
+// do returns the result of fn, or of the in-flight call for key. fn runs
+// detached from ctx so that one caller giving up does not fail the
+// others; a caller whose ctx ends first gets ctx.Err().
+func (g *flightGroup) do(ctx context.Context, key string, fn func(ctx context.Context) interface{}) (interface{}, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = map[string]*flight{}
+	}
+	f, ok := g.calls[key]
+	if !ok {
+		f = &flight{done: make(chan struct{})}
+		g.calls[key] = f
+		go func() {
+			f.val = fn(context.WithoutCancel(ctx))
+			g.mu.Lock()
+			delete(g.calls, key)
+			g.mu.Unlock()
+			close(f.done)
+		}()
+	}
+	g.mu.Unlock()
+
+	select {
+	case <-f.done:
+		return f.val, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
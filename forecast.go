@@ -0,0 +1,379 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxForecastDays  = 7
+	maxForecastHours = 48
+)
+
+// dailyForecast is one day of a provider forecast, in Celsius. date is
+// the calendar day at the forecast location, formatted as 2006-01-02.
+type dailyForecast struct {
+	date      string
+	high      float64
+	low       float64
+	condition string
+}
+
+// forecaster is implemented by providers that can forecast the coming
+// days, starting with today.
+type forecaster interface {
+	forecast(ctx context.Context, city string, days int) ([]dailyForecast, error)
+}
+
+// hourlyForecast is one hour of a provider forecast. Temperatures are in
+// Celsius and precipitation probability is a fraction between 0 and 1.
+type hourlyForecast struct {
+	time          time.Time
+	temp          float64
+	precipitation float64
+}
+
+// hourlyForecaster is implemented by providers that can forecast hour by
+// hour, starting with the current hour.
+type hourlyForecaster interface {
+	hourly(ctx context.Context, city string, hours int) ([]hourlyForecast, error)
+}
+
+// conditionCodes maps provider condition codes onto the small vocabulary
+// used in responses.
+var conditionCodes = map[string]string{
+	"clear-day":           "clear",
+	"clear-night":         "clear",
+	"clear":               "clear",
+	"sunny":               "clear",
+	"mostlysunny":         "partly-cloudy",
+	"partlysunny":         "partly-cloudy",
+	"partlycloudy":        "partly-cloudy",
+	"partly-cloudy-day":   "partly-cloudy",
+	"partly-cloudy-night": "partly-cloudy",
+	"mostlycloudy":        "cloudy",
+	"cloudy":              "cloudy",
+	"fog":                 "fog",
+	"hazy":                "fog",
+	"rain":                "rain",
+	"chancerain":          "rain",
+	"sleet":               "sleet",
+	"chancesleet":         "sleet",
+	"snow":                "snow",
+	"chancesnow":          "snow",
+	"flurries":            "snow",
+	"chanceflurries":      "snow",
+	"tstorms":             "thunderstorm",
+	"chancetstorms":       "thunderstorm",
+	"thunderstorm":        "thunderstorm",
+	"wind":                "wind",
+}
+
+func normalizeCondition(code string) string {
+	if c, ok := conditionCodes[strings.ToLower(code)]; ok {
+		return c
+	}
+	return "unknown"
+}
+
+// forecastDay is one aggregated day in a /forecast/ response.
+type forecastDay struct {
+	Date          string  `json:"date"`
+	High          float64 `json:"high"`
+	Low           float64 `json:"low"`
+	Condition     string  `json:"condition"`
+	ConditionText string  `json:"condition_text,omitempty"` // in the language of the request
+	Providers     int     `json:"providers"`
+}
+
+// forecastHour is one aggregated hour in a /forecast/{city}/hourly
+// response.
+type forecastHour struct {
+	Time          time.Time `json:"time"`
+	Temp          float64   `json:"temp"`
+	Precipitation float64   `json:"precip_probability"`
+	Providers     int       `json:"providers"`
+}
+
+type forecastReport struct {
+	City       string            `json:"city"`
+	Days       []forecastDay     `json:"days,omitempty"`
+	Hours      []forecastHour    `json:"hours,omitempty"`
+	Units      unitSystem        `json:"units"`
+	TempUnit   string            `json:"temp_unit"`
+	Aggregator string            `json:"aggregator"`
+	Failed     []providerFailure `json:"failed,omitempty"`
+	Took       string            `json:"took"`
+}
+
+// aggregateForecasts combines per-provider forecasts day by day with
+// pol. A provider that failed, or whose forecast does not reach a day,
+// counts as a failed reading for that day, so the policy's quorum
+// decides whether the day is reported. The condition is the one most
+// answering providers agree on.
+func aggregateForecasts(names []string, forecasts [][]dailyForecast, errs []error, days int, pol policy) []forecastDay {
+	byDate := map[string][]dailyForecast{}
+	var dates []string
+	for i, f := range forecasts {
+		if errs[i] != nil {
+			continue
+		}
+		for _, d := range f {
+			if _, ok := byDate[d.date]; !ok {
+				byDate[d.date] = make([]dailyForecast, len(forecasts))
+				dates = append(dates, d.date)
+			}
+			byDate[d.date][i] = d
+		}
+	}
+	sort.Strings(dates)
+
+	var out []forecastDay
+	for _, date := range dates {
+		var highs, lows []sourceReading
+		var conds []string
+		for i, d := range byDate[date] {
+			err := errs[i]
+			if err == nil && d.date == "" {
+				err = fmt.Errorf("no forecast for %s", date)
+			}
+			highs = append(highs, sourceReading{provider: names[i], temp: d.high, err: err})
+			lows = append(lows, sourceReading{provider: names[i], temp: d.low, err: err})
+			if err == nil {
+				conds = append(conds, d.condition)
+			}
+		}
+
+		high, err := pol.aggregate(highs)
+		if err != nil {
+			continue
+		}
+		low, err := pol.aggregate(lows)
+		if err != nil {
+			continue
+		}
+		out = append(out, forecastDay{
+			Date:      date,
+			High:      high.temp,
+			Low:       low.temp,
+			Condition: mostCommon(conds),
+			Providers: len(conds),
+		})
+		if len(out) == days {
+			break
+		}
+	}
+	return out
+}
+
+// aggregateHourly merges per-provider hourly forecasts whose timestamps
+// fall in the same hour. Temperatures are combined with pol like daily
+// ones; precipitation probabilities are averaged over the providers that
+// answered.
+func aggregateHourly(names []string, forecasts [][]hourlyForecast, errs []error, hours int, pol policy) []forecastHour {
+	byHour := map[int64][]*hourlyForecast{}
+	var stamps []int64
+	for i, f := range forecasts {
+		if errs[i] != nil {
+			continue
+		}
+		for j := range f {
+			h := f[j].time.Truncate(time.Hour).Unix()
+			if _, ok := byHour[h]; !ok {
+				byHour[h] = make([]*hourlyForecast, len(forecasts))
+				stamps = append(stamps, h)
+			}
+			byHour[h][i] = &f[j]
+		}
+	}
+	sort.Slice(stamps, func(i, j int) bool { return stamps[i] < stamps[j] })
+
+	var out []forecastHour
+	for _, stamp := range stamps {
+		var temps []sourceReading
+		precipitation, n := 0.0, 0
+		for i, h := range byHour[stamp] {
+			err := errs[i]
+			if err == nil && h == nil {
+				err = fmt.Errorf("no forecast for %s", time.Unix(stamp, 0).UTC().Format(time.RFC3339))
+			}
+			if err != nil {
+				temps = append(temps, sourceReading{provider: names[i], err: err})
+				continue
+			}
+			temps = append(temps, sourceReading{provider: names[i], temp: h.temp})
+			precipitation += h.precipitation
+			n++
+		}
+
+		c, err := pol.aggregate(temps)
+		if err != nil {
+			continue
+		}
+		out = append(out, forecastHour{
+			Time:          time.Unix(stamp, 0).UTC(),
+			Temp:          c.temp,
+			Precipitation: precipitation / float64(n),
+			Providers:     n,
+		})
+		if len(out) == hours {
+			break
+		}
+	}
+	return out
+}
+
+// mostCommon returns the most frequent value, preferring the earliest
+// on ties.
+func mostCommon(values []string) string {
+	counts := map[string]int{}
+	best := ""
+	for _, v := range values {
+		counts[v]++
+		if counts[v] > counts[best] {
+			best = v
+		}
+	}
+	return best
+}
+
+// forecast serves /forecast/{city}?days=N and /forecast/{city}/hourly
+// from the providers in mw that can forecast. Daily forecasts are also
+// available as one line of emoji and text per day.
+func forecast(mw multiWeatherProvider) http.HandlerFunc {
+	var daily, hourly []weatherProvider
+	for _, p := range mw {
+		if _, ok := p.(forecaster); ok {
+			daily = append(daily, p)
+		}
+		if _, ok := p.(hourlyForecaster); ok {
+			hourly = append(hourly, p)
+		}
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		begin := time.Now()
+		loc, err := requestLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		city := loc.query()
+		q := r.URL.Query()
+
+		isHourly := strings.HasSuffix(r.URL.Path, "/hourly")
+		providers, param, limit, n := daily, "days", maxForecastDays, 3
+		if isHourly {
+			providers, param, limit, n = hourly, "hours", maxForecastHours, 24
+		}
+		providers = enabledProviders(providers)
+
+		if len(providers) == 0 {
+			http.Error(w, "no configured provider supports this forecast", http.StatusNotImplemented)
+			return
+		}
+
+		units, err := parseUnitSystem(q.Get("units"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		pol := defaultPolicy
+		if agg := q.Get("agg"); agg != "" {
+			if pol.aggregator, err = parseAggregator(agg); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		if v := q.Get(param); v != "" {
+			if n, err = strconv.Atoi(v); err != nil || n < 1 || n > limit {
+				http.Error(w, fmt.Sprintf("%s must be between 1 and %d", param, limit), http.StatusBadRequest)
+				return
+			}
+		}
+
+		emoji, err := wantsEmoji(w, r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if emoji && isHourly {
+			// Hourly forecasts have no conditions; only refuse when asked
+			// for explicitly, not through Accept.
+			if q.Get("format") == "emoji" {
+				http.Error(w, "hourly forecasts have no conditions to show as emoji", http.StatusBadRequest)
+				return
+			}
+			emoji = false
+		}
+
+		names := make([]string, len(providers))
+		days := make([][]dailyForecast, len(providers))
+		hours := make([][]hourlyForecast, len(providers))
+		errs := make([]error, len(providers))
+		var wg sync.WaitGroup
+		for i, p := range providers {
+			names[i] = providerName(p)
+			wg.Add(1)
+			go func(i int, p weatherProvider) {
+				defer wg.Done()
+				ctx, cancel := providerContext(r.Context(), p)
+				defer cancel()
+				if isHourly {
+					hours[i], errs[i] = p.(hourlyForecaster).hourly(ctx, city, n)
+				} else {
+					days[i], errs[i] = p.(forecaster).forecast(ctx, city, n)
+				}
+			}(i, p)
+		}
+		wg.Wait()
+
+		var failed []providerFailure
+		for i, err := range errs {
+			if err != nil {
+				failed = append(failed, providerFailure{names[i], publicError(err)})
+			}
+		}
+		if len(failed) == len(providers) {
+			http.Error(w, "no provider returned a forecast", http.StatusInternalServerError)
+			return
+		}
+
+		report := forecastReport{
+			City:       city,
+			Units:      units,
+			TempUnit:   units.temperatureUnit(),
+			Aggregator: pol.aggregator.name(),
+			Failed:     failed,
+		}
+		if isHourly {
+			report.Hours = aggregateHourly(names, hours, errs, n, pol)
+		} else {
+			report.Days = aggregateForecasts(names, days, errs, n, pol)
+		}
+		tr := localize(w, r)
+		for i := range report.Days {
+			report.Days[i].High = units.temperature(report.Days[i].High)
+			report.Days[i].Low = units.temperature(report.Days[i].Low)
+			report.Days[i].ConditionText = tr.condition(report.Days[i].Condition)
+		}
+		for i := range report.Hours {
+			report.Hours[i].Temp = units.temperature(report.Hours[i].Temp)
+		}
+		report.Took = time.Since(begin).String()
+
+		if emoji {
+			writeEmojiDays(w, report.Days, report.TempUnit)
+			return
+		}
+		writeJSON(w, r, report)
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// geocodeTTL is how long a city's coordinates are reused. Cities do not
+// move; the limit only lets upstream corrections through eventually.
+const geocodeTTL = 30 * 24 * time.Hour
+
+// geocodeCache remembers what city queries resolve to so that providers which
+// geocode through OpenWeatherMap keep working while it is down. Entries
+// live in the shared Cache and, when file is set, are also written to
+// that JSON file and reloaded at startup.
+type geocodeCache struct {
+	store Cache
+	file  string
+
+	mu    sync.Mutex
+	saved map[string]place
+}
+
+// geocodes is the process-wide geocode cache; loadConfig replaces it.
+var geocodes = &geocodeCache{store: newMemoryCache(), saved: map[string]place{}}
+
+func newGeocodeCache(store Cache, file string) (*geocodeCache, error) {
+	g := &geocodeCache{store: store, file: file, saved: map[string]place{}}
+	if file == "" {
+		return g, nil
+	}
+
+	b, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return g, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &g.saved); err != nil {
+		return nil, err
+	}
+	for query, p := range g.saved {
+		// Files written before places carried a name only hold
+		// coordinates; those cities are looked up again.
+		if p.City == "" {
+			delete(g.saved, query)
+			continue
+		}
+		g.put(query, p)
+	}
+	return g, nil
+}
+
+func (g *geocodeCache) put(query string, p place) {
+	b, _ := json.Marshal(p)
+	g.store.set("place:"+strings.ToLower(query), b, geocodeTTL)
+}
+
+func (g *geocodeCache) lookup(query string) (place, bool) {
+	b, ok := g.store.get("place:" + strings.ToLower(query))
+	if !ok {
+		return place{}, false
+	}
+	var p place
+	if err := json.Unmarshal(b, &p); err != nil {
+		return place{}, false
+	}
+	return p, true
+}
+
+// remember caches the place a query resolved to and, if persistence is
+// on, rewrites the file. The file is replaced atomically so a crash
+// never truncates it.
+func (g *geocodeCache) remember(query string, p place) {
+	g.put(query, p)
+	if g.file == "" {
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	query = strings.ToLower(query)
+	if c, ok := g.saved[query]; ok && c == p {
+		return
+	}
+	g.saved[query] = p
+
+	b, err := json.MarshalIndent(g.saved, "", "\t")
+	if err != nil {
+		return
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(g.file), ".geocode-*")
+	if err != nil {
+		slog.Warn("geocode cache", "file", g.file, "error", err)
+		return
+	}
+	_, err = tmp.Write(b)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), g.file)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		slog.Warn("geocode cache", "file", g.file, "error", err)
+	}
+}
+
+// Geocoder resolves a city query, a name or "name,CC" with an ISO
+// 3166-1 alpha-2 country code, to a place.
+type Geocoder interface {
+	geocode(ctx context.Context, query string) (place, error)
+}
+
+// geocoderNames maps the names accepted by the geocoders setting to
+// backends. OpenWeatherMap here means its weather endpoint; loadConfig
+// gives it the provider's API key.
+var geocoderNames = map[string]Geocoder{
+	"openWeatherMap": openWeatherMap{},
+	"nominatim":      nominatim{},
+	"openMeteo":      openMeteoGeocoder{},
+}
+
+// geocoders are tried in order until one resolves a query; loadConfig
+// sets them from the geocoders setting.
+var geocoders = []Geocoder{openMeteoGeocoder{}}
+
+// locate resolves query through the geocode cache, then each geocoder
+// in turn. The error lists every backend's failure, without the request
+// URLs, which can carry API keys.
+func locate(ctx context.Context, query string) (p place, err error) {
+	// Geocoding failures are not the calling provider's.
+	ctx = context.WithValue(ctx, providerKey{}, "")
+	ctx, s := startSpan(ctx, "geocode", spanInternal)
+	s.set("geocode.query", query)
+	defer func() { s.finish(err) }()
+
+	if p, ok := geocodes.lookup(query); ok {
+		s.set("cache.hit", true)
+		return p, nil
+	}
+
+	var errs []string
+	for _, g := range geocoders {
+		p, err := g.geocode(ctx, query)
+		if err != nil {
+			errs = append(errs, publicError(err))
+			continue
+		}
+		geocodes.remember(query, p)
+		return p, nil
+	}
+	return place{}, fmt.Errorf("cannot locate %q: %s", query, strings.Join(errs, "; "))
+}
+
+func locateCoord(ctx context.Context, query string) (Coord, error) {
+	p, err := locate(ctx, query)
+	return p.Coord, err
+}
+
+func (nominatim) geocode(ctx context.Context, query string) (place, error) {
+	name, cc, _ := strings.Cut(query, ",")
+	u := baseURL("nominatim") + "/search?format=jsonv2&limit=1&addressdetails=1&q=" + url.QueryEscape(name)
+	if cc != "" {
+		u += "&countrycodes=" + url.QueryEscape(strings.ToLower(cc))
+	}
+	resp, err := httpGet(ctx, u)
+	if err != nil {
+		return place{}, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return place{}, fmt.Errorf("nominatim: %s", resp.Status)
+	}
+
+	var d []struct {
+		Name    string `json:"name"`
+		Lat     string `json:"lat"`
+		Lon     string `json:"lon"`
+		Address struct {
+			Country string `json:"country_code"`
+		} `json:"address"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&d); err != nil {
+		return place{}, err
+	}
+	if len(d) == 0 {
+		return place{}, fmt.Errorf("nominatim: no match")
+	}
+
+	lat, errLat := strconv.ParseFloat(d[0].Lat, 64)
+	lon, errLon := strconv.ParseFloat(d[0].Lon, 64)
+	if errLat != nil || errLon != nil {
+		return place{}, fmt.Errorf("nominatim: bad coordinates %q,%q", d[0].Lat, d[0].Lon)
+	}
+	return place{City: d[0].Name, Country: strings.ToUpper(d[0].Address.Country), Coord: Coord{lon, lat}}, nil
+}
+
+// openMeteoGeocoder is Open-Meteo's keyless geocoding API, built on
+// GeoNames.
+type openMeteoGeocoder struct{}
+
+func (openMeteoGeocoder) geocode(ctx context.Context, query string) (place, error) {
+	name, cc, _ := strings.Cut(query, ",")
+	u := baseURL("openMeteoGeocoding") + "/v1/search?count=1&name=" + url.QueryEscape(name)
+	if cc != "" {
+		u += "&countryCode=" + url.QueryEscape(strings.ToUpper(cc))
+	}
+	resp, err := httpGet(ctx, u)
+	if err != nil {
+		return place{}, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return place{}, fmt.Errorf("openMeteo: %s", resp.Status)
+	}
+
+	var d struct {
+		Results []struct {
+			Name      string  `json:"name"`
+			Latitude  float64 `json:"latitude"`
+			Longitude float64 `json:"longitude"`
+			Country   string  `json:"country_code"`
+		} `json:"results"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&d); err != nil {
+		return place{}, err
+	}
+	if len(d.Results) == 0 {
+		return place{}, fmt.Errorf("openMeteo: no match")
+	}
+
+	r := d.Results[0]
+	return place{City: r.Name, Country: r.Country, Coord: Coord{r.Longitude, r.Latitude}}, nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type failingTransport struct{}
+
+func (failingTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, errors.New("connection refused")
+}
+
+// TestLocateHidesAPIKey checks that a geocoder's failing request URL, and
+// the API key in it, never reach a response.
+func TestLocateHidesAPIKey(t *testing.T) {
+	const key = "SECRETKEY123"
+	defer func(g []Geocoder, tr http.RoundTripper) { geocoders, upstreamClient.Transport = g, tr }(geocoders, upstreamClient.Transport)
+	geocoders = []Geocoder{openWeatherMap{apiKey: key}, nominatim{}}
+	upstreamClient.Transport = failingTransport{}
+
+	rec := httptest.NewRecorder()
+	coordinates(rec, httptest.NewRequest("GET", "/v1/coordinates?city=Nowhereville", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+	if body := rec.Body.String(); strings.Contains(body, key) || !strings.Contains(body, "connection refused") {
+		t.Errorf("body %q: want the failure without the key", body)
+	}
+}
+
+func TestPublicErrorWrapped(t *testing.T) {
+	_, err := (&http.Client{Transport: failingTransport{}}).Get("http://example.com/?appid=SECRETKEY123")
+	for _, err := range []error{
+		err,
+		fmt.Errorf("provider: %w", err),
+		errors.Join(errors.New("other"), fmt.Errorf("provider: %w", err)),
+	} {
+		if msg := publicError(err); strings.Contains(msg, "SECRETKEY123") || !strings.Contains(msg, "connection refused") {
+			t.Errorf("publicError(%q) = %q", err, msg)
+		}
+	}
+}
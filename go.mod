@@ -0,0 +1,3 @@
+module github.com/eugene-v/hello_world
+
+go 1.23
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxGridCells     = 400              // cells in one /grid response
+	minGridStep      = 0.01             // degrees, about a kilometre
+	gridWorkers      = 8                // concurrent cell lookups
+	gridCellTTL      = 10 * time.Minute // cell cache lifetime
+	maxGridCacheSize = 10000
+)
+
+type gridCell struct {
+	temp    float64
+	expires time.Time
+}
+
+// grid serves /grid?bbox=minLon,minLat,maxLon,maxLat&step=0.5: it samples
+// every coordinate-capable provider over a lat/lon grid and returns a
+// matrix of mean temperatures suitable for heatmaps. Cells are cached for
+// gridCellTTL and looked up by a bounded pool of workers. With
+// ?format=geojson the cells are the point features of a FeatureCollection.
+type grid struct {
+	providers []coordinateProvider
+
+	mu    sync.Mutex
+	cells map[string]gridCell
+}
+
+func newGrid(mw multiWeatherProvider) *grid {
+	g := &grid{cells: map[string]gridCell{}}
+	for _, p := range mw {
+		if c, ok := p.(coordinateProvider); ok {
+			g.providers = append(g.providers, c)
+		}
+	}
+	return g
+}
+
+func (g *grid) temperatureAt(ctx context.Context, coord Coord) (float64, error) {
+	key := fmt.Sprintf("%.4f,%.4f", coord.Lat, coord.Lon)
+
+	g.mu.Lock()
+	c, ok := g.cells[key]
+	g.mu.Unlock()
+	if ok && time.Now().Before(c.expires) {
+		return c.temp, nil
+	}
+
+	sum, n := 0.0, 0
+	for _, p := range g.providers {
+		if providerDisabled(p) {
+			continue
+		}
+		pctx, cancel := providerContext(ctx, p)
+		t, err := p.temperatureAt(pctx, coord)
+		cancel()
+		if err != nil {
+			return 0, err
+		}
+		sum += t
+		n++
+	}
+	if n == 0 {
+		return 0, fmt.Errorf("every coordinate provider is disabled")
+	}
+	temp := sum / float64(n)
+
+	g.mu.Lock()
+	if len(g.cells) >= maxGridCacheSize {
+		for k, c := range g.cells {
+			if time.Now().After(c.expires) {
+				delete(g.cells, k)
+			}
+		}
+		if len(g.cells) >= maxGridCacheSize {
+			g.cells = map[string]gridCell{}
+		}
+	}
+	g.cells[key] = gridCell{temp: temp, expires: time.Now().Add(gridCellTTL)}
+	g.mu.Unlock()
+
+	return temp, nil
+}
+
+// stepCount is how many of min, min+step, ... fall within max. It is a
+// float so that callers can check the size of a grid before building it.
+func stepCount(min, max, step float64) float64 {
+	return math.Floor((max-min)/step+1e-6) + 1
+}
+
+// steps returns the n values min, min+step, ... from stepCount.
+func steps(min, step float64, n int) []float64 {
+	s := make([]float64, n)
+	for i := range s {
+		s[i] = math.Round((min+float64(i)*step)*1e6) / 1e6
+	}
+	return s
+}
+
+func parseBBox(s string) ([4]float64, error) {
+	var b [4]float64
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return b, fmt.Errorf("bbox must be minLon,minLat,maxLon,maxLat")
+	}
+	for i, p := range parts {
+		v, err := strconv.ParseFloat(p, 64)
+		if err != nil {
+			return b, fmt.Errorf("bbox must be minLon,minLat,maxLon,maxLat")
+		}
+		b[i] = v
+	}
+	if b[0] < -180 || b[2] > 180 || b[1] < -90 || b[3] > 90 || b[0] > b[2] || b[1] > b[3] {
+		return b, fmt.Errorf("bbox out of range or inverted")
+	}
+	return b, nil
+}
+
+func (g *grid) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	begin := time.Now()
+	q := r.URL.Query()
+
+	if len(g.providers) == 0 {
+		http.Error(w, "no configured provider can look up coordinates", http.StatusNotImplemented)
+		return
+	}
+
+	units, err := parseUnitSystem(q.Get("units"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	format, err := parseFormat(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	bbox, err := parseBBox(q.Get("bbox"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	step := 0.5
+	if v := q.Get("step"); v != "" {
+		if step, err = strconv.ParseFloat(v, 64); err != nil || !(step >= minGridStep) {
+			http.Error(w, fmt.Sprintf("step must be at least %g degrees", minGridStep), http.StatusBadRequest)
+			return
+		}
+	}
+
+	nLon, nLat := stepCount(bbox[0], bbox[2], step), stepCount(bbox[1], bbox[3], step)
+	if nLon*nLat > maxGridCells {
+		http.Error(w, fmt.Sprintf("grid of %gx%g exceeds %d cells, use a larger step", nLat, nLon, maxGridCells), http.StatusBadRequest)
+		return
+	}
+	lons, lats := steps(bbox[0], step, int(nLon)), steps(bbox[1], step, int(nLat))
+
+	// temps[i][j] is the temperature at lats[i], lons[j]; cells whose
+	// lookup failed stay null.
+	temps := make([][]*float64, len(lats))
+	for i := range temps {
+		temps[i] = make([]*float64, len(lons))
+	}
+
+	type cell struct{ i, j int }
+	next := make(chan cell)
+	var wg sync.WaitGroup
+	for n := 0; n < gridWorkers; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range next {
+				t, err := g.temperatureAt(r.Context(), Coord{Lon: lons[c.j], Lat: lats[c.i]})
+				if err == nil {
+					t = units.temperature(t)
+					temps[c.i][c.j] = &t
+				}
+			}
+		}()
+	}
+	for i := range lats {
+		for j := range lons {
+			next <- cell{i, j}
+		}
+	}
+	close(next)
+	wg.Wait()
+
+	if format == "geojson" {
+		var features []geoFeature
+		for i, lat := range lats {
+			for j, lon := range lons {
+				features = append(features, geoFeature{&Coord{Lon: lon, Lat: lat}, map[string]interface{}{
+					"temp":      temps[i][j],
+					"temp_unit": units.temperatureUnit(),
+				}})
+			}
+		}
+		writeFeatureCollection(w, r, features)
+		return
+	}
+	writeJSON(w, r, map[string]interface{}{
+		"bbox":      bbox,
+		"step":      step,
+		"lats":      lats,
+		"lons":      lons,
+		"temps":     temps,
+		"units":     units,
+		"temp_unit": units.temperatureUnit(),
+		"took":      time.Since(begin).String(),
+	})
+}
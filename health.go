@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Health settings, from health_min_success and health_max_p95 in the
+// config. Zero disables either check.
+var (
+	healthMinSuccess = 0.5
+	healthMaxP95     time.Duration
+)
+
+const (
+	// healthWindow is how many recent lookups a provider's score covers,
+	// and healthMinSamples how many it needs before it can be excluded.
+	healthWindow     = 20
+	healthMinSamples = 10
+
+	// healthProbeInterval is how often an excluded provider is still
+	// queried, in the background, so that it can recover.
+	healthProbeInterval = 30 * time.Second
+)
+
+type healthSample struct {
+	ok      bool
+	latency time.Duration
+}
+
+// providerHealth is the rolling record of one provider's lookups.
+type providerHealth struct {
+	samples   []healthSample // ring buffer of the last healthWindow
+	next      int
+	lastProbe time.Time
+}
+
+// healthScore summarizes a provider's recent lookups.
+type healthScore struct {
+	Samples     int     `json:"samples"`
+	SuccessRate float64 `json:"success_rate"`
+	P95         string  `json:"p95"` // of successful lookups
+	Healthy     bool    `json:"healthy"`
+
+	p95 time.Duration
+}
+
+var health = struct {
+	sync.Mutex
+	m map[string]*providerHealth
+}{m: map[string]*providerHealth{}}
+
+// recordHealth adds the outcome of a lookup to the provider's record.
+// Lookups that say nothing about the provider are ignored: a location it
+// does not cover, its open breaker, or a client that gave up.
+func recordHealth(ctx context.Context, name string, latency time.Duration, err error) {
+	if errors.Is(err, errNotCovered) || errors.Is(err, errCircuitOpen) || errors.Is(ctx.Err(), context.Canceled) {
+		return
+	}
+
+	health.Lock()
+	defer health.Unlock()
+	h := health.m[name]
+	if h == nil {
+		h = &providerHealth{}
+		health.m[name] = h
+	}
+	s := healthSample{ok: err == nil, latency: latency}
+	if len(h.samples) < healthWindow {
+		h.samples = append(h.samples, s)
+	} else {
+		h.samples[h.next] = s
+	}
+	h.next = (h.next + 1) % healthWindow
+}
+
+func (h *providerHealth) score() healthScore {
+	sc := healthScore{Samples: len(h.samples), SuccessRate: 1, Healthy: true}
+	var ok []time.Duration
+	for _, s := range h.samples {
+		if s.ok {
+			ok = append(ok, s.latency)
+		}
+	}
+	if len(h.samples) > 0 {
+		sc.SuccessRate = float64(len(ok)) / float64(len(h.samples))
+	}
+	if len(ok) > 0 {
+		sort.Slice(ok, func(i, j int) bool { return ok[i] < ok[j] })
+		sc.p95 = ok[(len(ok)*95+99)/100-1]
+	}
+	sc.P95 = sc.p95.String()
+
+	if len(h.samples) >= healthMinSamples {
+		if healthMinSuccess > 0 && sc.SuccessRate < healthMinSuccess {
+			sc.Healthy = false
+		}
+		if healthMaxP95 > 0 && sc.p95 > healthMaxP95 {
+			sc.Healthy = false
+		}
+	}
+	return sc
+}
+
+// providerScore returns the named provider's current score.
+func providerScore(name string) healthScore {
+	health.Lock()
+	defer health.Unlock()
+	if h := health.m[name]; h != nil {
+		return h.score()
+	}
+	return (&providerHealth{}).score()
+}
+
+// probeDue reports whether an excluded provider should be queried again,
+// and if so counts the probe as started.
+func probeDue(name string) bool {
+	health.Lock()
+	defer health.Unlock()
+	h := health.m[name]
+	if h == nil || time.Since(h.lastProbe) < healthProbeInterval {
+		return false
+	}
+	h.lastProbe = time.Now()
+	return true
+}
+
+// healthyProviders splits ps into the providers fit for aggregation and
+// those excluded for poor health. If every provider is unhealthy none is
+// excluded: a poor answer beats none.
+func healthyProviders(ps []weatherProvider) (healthy, excluded []weatherProvider) {
+	for _, p := range ps {
+		if providerScore(providerName(p)).Healthy {
+			healthy = append(healthy, p)
+		} else {
+			excluded = append(excluded, p)
+		}
+	}
+	if len(healthy) == 0 {
+		return excluded, nil
+	}
+	return healthy, excluded
+}
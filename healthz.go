@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// readyCheckInterval is how often /readyz may query upstreams; checks in
+// between reuse the last answer.
+const readyCheckInterval = 30 * time.Second
+
+// healthz is the liveness check: the process is up and serving.
+func healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write([]byte("ok\n"))
+}
+
+// readiness answers /readyz: ready while the report cache holds fresh
+// reports or some provider can answer a lookup for city, and not once
+// shutdown has begun.
+type readiness struct {
+	mw    multiWeatherProvider
+	cache *reportCache
+	city  string
+
+	mu      sync.Mutex
+	checked time.Time
+	ready   readyState
+}
+
+type readyState struct {
+	Ready    bool   `json:"ready"`
+	Provider string `json:"provider,omitempty"` // that answered the last check
+	Reason   string `json:"reason,omitempty"`
+}
+
+func newReadiness(mw multiWeatherProvider, cache *reportCache, city string) *readiness {
+	return &readiness{mw: mw, cache: cache, city: city}
+}
+
+// check looks up city with every enabled provider, at most once per
+// readyCheckInterval.
+func (rd *readiness) check(ctx context.Context) readyState {
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+	if time.Since(rd.checked) < readyCheckInterval {
+		return rd.ready
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	state := readyState{Reason: "no provider answered a lookup for " + rd.city}
+	for _, r := range rd.mw.fanOut(ctx, Location{City: rd.city}) {
+		if r.err == nil {
+			state = readyState{Ready: true, Provider: r.provider}
+			break
+		}
+	}
+	rd.checked, rd.ready = time.Now(), state
+	return state
+}
+
+func (rd *readiness) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var state readyState
+	select {
+	case <-shuttingDown:
+		state = readyState{Reason: "shutting down"}
+	default:
+		if rd.cache.warm() {
+			state = readyState{Ready: true, Reason: "cache is warm"}
+		} else {
+			state = rd.check(r.Context())
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if !state.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(state)
+}
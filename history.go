@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// earliestHistory is the first day /history/ accepts; the reanalysis
+// archives providers build on start in 1940.
+var earliestHistory = time.Date(1940, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// historian is implemented by providers that can report the observed
+// high, low and condition of a past day. date is midnight UTC of that
+// day.
+type historian interface {
+	history(ctx context.Context, city string, date time.Time) (dailyForecast, error)
+}
+
+type historyReport struct {
+	City string `json:"city"`
+	forecastDay
+	Units      unitSystem        `json:"units"`
+	TempUnit   string            `json:"temp_unit"`
+	Aggregator string            `json:"aggregator"`
+	Failed     []providerFailure `json:"failed,omitempty"`
+	Took       string            `json:"took"`
+}
+
+// parseHistoryDate parses a YYYY-MM-DD date that lies between
+// earliestHistory and yesterday, in UTC.
+func parseHistoryDate(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, fmt.Errorf("date is required, as YYYY-MM-DD")
+	}
+	date, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q, want YYYY-MM-DD", s)
+	}
+	if date.Before(earliestHistory) || !date.Before(time.Now().UTC().Truncate(24*time.Hour)) {
+		return time.Time{}, fmt.Errorf("date must be between %s and yesterday", earliestHistory.Format("2006-01-02"))
+	}
+	return date, nil
+}
+
+// history serves /history/{city}?date=YYYY-MM-DD from the providers in mw
+// that implement historian, combining their highs and lows like a
+// forecast day.
+func history(mw multiWeatherProvider) http.HandlerFunc {
+	var providers []weatherProvider
+	for _, p := range mw {
+		if _, ok := p.(historian); ok {
+			providers = append(providers, p)
+		}
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		begin := time.Now()
+		loc, err := requestLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		city := loc.query()
+		q := r.URL.Query()
+
+		providers := enabledProviders(providers)
+		if len(providers) == 0 {
+			http.Error(w, "no configured provider has historical data", http.StatusNotImplemented)
+			return
+		}
+
+		date, err := parseHistoryDate(q.Get("date"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		units, err := parseUnitSystem(q.Get("units"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		pol := defaultPolicy
+		if agg := q.Get("agg"); agg != "" {
+			if pol.aggregator, err = parseAggregator(agg); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		names := make([]string, len(providers))
+		days := make([][]dailyForecast, len(providers))
+		errs := make([]error, len(providers))
+		var wg sync.WaitGroup
+		for i, p := range providers {
+			names[i] = providerName(p)
+			wg.Add(1)
+			go func(i int, p weatherProvider) {
+				defer wg.Done()
+				ctx, cancel := providerContext(r.Context(), p)
+				defer cancel()
+				d, err := p.(historian).history(ctx, city, date)
+				days[i], errs[i] = []dailyForecast{d}, err
+			}(i, p)
+		}
+		wg.Wait()
+
+		report := historyReport{
+			City:       city,
+			Units:      units,
+			TempUnit:   units.temperatureUnit(),
+			Aggregator: pol.aggregator.name(),
+		}
+		for i, err := range errs {
+			if err != nil {
+				report.Failed = append(report.Failed, providerFailure{names[i], publicError(err)})
+			}
+		}
+
+		combined := aggregateForecasts(names, days, errs, 1, pol)
+		if len(combined) == 0 {
+			http.Error(w, fmt.Sprintf("not enough providers have data for %s", date.Format("2006-01-02")), http.StatusInternalServerError)
+			return
+		}
+		report.forecastDay = combined[0]
+		report.High = units.temperature(report.High)
+		report.Low = units.temperature(report.Low)
+		report.ConditionText = localize(w, r).condition(report.Condition)
+		report.Took = time.Since(begin).String()
+
+		writeJSON(w, r, report)
+	}
+}
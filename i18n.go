@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// defaultLanguage is the last step of every language fallback chain.
+const defaultLanguage = "en"
+
+// translation is the response text of one language: condition
+// descriptions by condition code, CAP severities, and the format of an
+// alert summary, which is given the severity and the alert type. Alert
+// types are the provider's own text and are not translated.
+type translation struct {
+	conditions map[string]string
+	severities map[string]string
+	alert      string
+}
+
+var translations = map[string]translation{
+	"en": {
+		conditions: map[string]string{
+			"clear": "Clear", "partly-cloudy": "Partly cloudy", "cloudy": "Cloudy", "fog": "Fog", "rain": "Rain",
+			"sleet": "Sleet", "snow": "Snow", "thunderstorm": "Thunderstorm", "wind": "Windy", "unknown": "Unknown",
+		},
+		severities: map[string]string{
+			"minor": "Minor", "moderate": "Moderate", "severe": "Severe", "extreme": "Extreme", "unknown": "Unknown",
+		},
+		alert: "%s alert: %s",
+	},
+	"de": {
+		conditions: map[string]string{
+			"clear": "Klar", "partly-cloudy": "Teilweise bewölkt", "cloudy": "Bewölkt", "fog": "Nebel", "rain": "Regen",
+			"sleet": "Schneeregen", "snow": "Schnee", "thunderstorm": "Gewitter", "wind": "Windig", "unknown": "Unbekannt",
+		},
+		severities: map[string]string{
+			"minor": "gering", "moderate": "mäßig", "severe": "schwer", "extreme": "extrem", "unknown": "unbekannt",
+		},
+		alert: "Warnung (%s): %s",
+	},
+	"fr": {
+		conditions: map[string]string{
+			"clear": "Dégagé", "partly-cloudy": "Partiellement nuageux", "cloudy": "Nuageux", "fog": "Brouillard", "rain": "Pluie",
+			"sleet": "Neige fondue", "snow": "Neige", "thunderstorm": "Orage", "wind": "Venteux", "unknown": "Inconnu",
+		},
+		severities: map[string]string{
+			"minor": "mineure", "moderate": "modérée", "severe": "sévère", "extreme": "extrême", "unknown": "inconnue",
+		},
+		alert: "Alerte (%s) : %s",
+	},
+	"es": {
+		conditions: map[string]string{
+			"clear": "Despejado", "partly-cloudy": "Parcialmente nublado", "cloudy": "Nublado", "fog": "Niebla", "rain": "Lluvia",
+			"sleet": "Aguanieve", "snow": "Nieve", "thunderstorm": "Tormenta", "wind": "Ventoso", "unknown": "Desconocido",
+		},
+		severities: map[string]string{
+			"minor": "menor", "moderate": "moderada", "severe": "grave", "extreme": "extrema", "unknown": "desconocida",
+		},
+		alert: "Alerta (%s): %s",
+	},
+}
+
+// requestLanguage picks the language of a response's text. The fallback
+// chain is ?lang=, then the Accept-Language tags by preference, then
+// English; a regional tag such as de-AT falls back to its language.
+// Languages without a translation are skipped.
+func requestLanguage(r *http.Request) string {
+	tags := []string{r.URL.Query().Get("lang")}
+	tags = append(tags, acceptedLanguages(r.Header.Get("Accept-Language"))...)
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		base, _, _ := strings.Cut(tag, "-")
+		for _, lang := range []string{tag, base} {
+			if _, ok := translations[lang]; ok {
+				return lang
+			}
+		}
+	}
+	return defaultLanguage
+}
+
+// acceptedLanguages returns the tags of an Accept-Language header, most
+// preferred first, without the ones refused with q=0.
+func acceptedLanguages(header string) []string {
+	type accepted struct {
+		tag string
+		q   float64
+	}
+	var langs []accepted
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if tag = strings.TrimSpace(tag); tag != "" && tag != "*" && q > 0 {
+			langs = append(langs, accepted{tag, q})
+		}
+	}
+	sort.SliceStable(langs, func(i, j int) bool { return langs[i].q > langs[j].q })
+
+	tags := make([]string, len(langs))
+	for i, l := range langs {
+		tags[i] = l.tag
+	}
+	return tags
+}
+
+// localize picks the response language for r, announces it in the
+// response headers and returns its translation.
+func localize(w http.ResponseWriter, r *http.Request) translation {
+	lang := requestLanguage(r)
+	w.Header().Set("Content-Language", lang)
+	w.Header().Add("Vary", "Accept-Language")
+	return translations[lang]
+}
+
+// condition describes a condition code, in English when the language
+// lacks it.
+func (t translation) condition(code string) string {
+	if s, ok := t.conditions[code]; ok {
+		return s
+	}
+	return translations[defaultLanguage].conditions[code]
+}
+
+// alertSummary sums up a in one line.
+func (t translation) alertSummary(a weatherAlert) string {
+	severity, ok := t.severities[a.Severity]
+	if !ok {
+		severity = t.severities["unknown"]
+	}
+	return fmt.Sprintf(t.alert, severity, a.Type)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequestLanguage(t *testing.T) {
+	tests := []struct {
+		query, header, want string
+	}{
+		{"", "", "en"},
+		{"lang=fr", "de", "fr"},
+		{"lang=xx", "de-AT, en;q=0.5", "de"},
+		{"", "ja, es;q=0.8, de;q=0.9", "de"},
+		{"", "de;q=0, fr;q=0.1", "fr"},
+		{"", "pt-BR, *", "en"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/v1/forecast/Oslo?"+tt.query, nil)
+		r.Header.Set("Accept-Language", tt.header)
+		if got := requestLanguage(r); got != tt.want {
+			t.Errorf("?%s, Accept-Language %q: %s, want %s", tt.query, tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestTranslationsComplete(t *testing.T) {
+	en := translations[defaultLanguage]
+	for lang, tr := range translations {
+		for code := range en.conditions {
+			if tr.conditions[code] == "" {
+				t.Errorf("%s: no text for condition %s", lang, code)
+			}
+		}
+		for sev := range en.severities {
+			if tr.severities[sev] == "" {
+				t.Errorf("%s: no text for severity %s", lang, sev)
+			}
+		}
+	}
+	if got := translations["de"].alertSummary(weatherAlert{Type: "Sturmflut", Severity: "severe"}); got != "Warnung (schwer): Sturmflut" {
+		t.Errorf("summary %q", got)
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	maxJobCities  = 1000      // cities accepted in one batch job
+	maxActiveJobs = 4         // jobs running at once
+	jobWorkers    = 8         // concurrent lookups across all jobs
+	jobRetention  = time.Hour // how long finished jobs stay downloadable
+)
+
+var errTooManyJobs = fmt.Errorf("%d jobs are already running, try again later", maxActiveJobs)
+
+type jobResult struct {
+	City  string   `json:"city"`
+	Temp  *float64 `json:"temp,omitempty"` // Celsius, nil on error
+	Error string   `json:"error,omitempty"`
+}
+
+// job is one batch of cities looked up in the background. results is
+// indexed like the submitted city list.
+type job struct {
+	id      string
+	created time.Time
+
+	mu       sync.Mutex
+	done     int
+	results  []jobResult
+	finished time.Time
+}
+
+type jobStatus struct {
+	ID       string     `json:"id"`
+	Status   string     `json:"status"`
+	Total    int        `json:"total"`
+	Done     int        `json:"done"`
+	Created  time.Time  `json:"created"`
+	Finished *time.Time `json:"finished,omitempty"`
+}
+
+func (j *job) status() jobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	s := jobStatus{ID: j.id, Status: "running", Total: len(j.results), Done: j.done, Created: j.created}
+	if !j.finished.IsZero() {
+		f := j.finished
+		s.Status, s.Finished = "done", &f
+	}
+	return s
+}
+
+// jobQueue runs batch lookups asynchronously. Clients POST a list of
+// cities to /v1/jobs/, poll /v1/jobs/{id} for progress and download
+// /v1/jobs/{id}/results once the job is done. Lookups go through the
+// report cache like /v1/weather/batch, and all jobs share jobWorkers
+// lookup slots, so that large jobs cannot monopolize upstream quota.
+type jobQueue struct {
+	provider weatherProvider
+	cache    *reportCache
+	slots    chan struct{}
+
+	mu   sync.Mutex
+	jobs map[string]*job
+}
+
+func newJobQueue(p weatherProvider, cache *reportCache) *jobQueue {
+	return &jobQueue{provider: p, cache: cache, slots: make(chan struct{}, jobWorkers), jobs: map[string]*job{}}
+}
+
+func (q *jobQueue) submit(cities []string) (*job, error) {
+	b := make([]byte, 8)
+	rand.Read(b)
+
+	j := &job{id: hex.EncodeToString(b), created: time.Now(), results: make([]jobResult, len(cities))}
+
+	q.mu.Lock()
+	active := 0
+	for id, old := range q.jobs {
+		s := old.status()
+		switch {
+		case s.Finished == nil:
+			active++
+		case time.Since(*s.Finished) > jobRetention:
+			delete(q.jobs, id)
+		}
+	}
+	if active >= maxActiveJobs {
+		q.mu.Unlock()
+		return nil, errTooManyJobs
+	}
+	q.jobs[j.id] = j
+	q.mu.Unlock()
+
+	go q.run(j, cities)
+	return j, nil
+}
+
+func (q *jobQueue) run(j *job, cities []string) {
+	var wg sync.WaitGroup
+	for i, city := range cities {
+		q.slots <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-q.slots
+				wg.Done()
+			}()
+
+			r := jobResult{City: city}
+			loc, err := parseCity(city, "")
+			if err == nil {
+				var report weatherReport
+				if report, _, _, err = fetchReport(context.Background(), q.provider, q.cache, loc, metric, defaultPolicy); err == nil {
+					r.Temp = &report.Temp
+				}
+			}
+			if err != nil {
+				r.Error = publicError(err)
+			}
+
+			j.mu.Lock()
+			j.results[i] = r
+			j.done++
+			j.mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	j.mu.Lock()
+	j.finished = time.Now()
+	j.mu.Unlock()
+}
+
+// job returns the job named in the path, or answers 404.
+func (q *jobQueue) job(w http.ResponseWriter, r *http.Request) (*job, bool) {
+	q.mu.Lock()
+	j, ok := q.jobs[r.PathValue("id")]
+	q.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+	}
+	return j, ok
+}
+
+func (q *jobQueue) status(w http.ResponseWriter, r *http.Request) {
+	if j, ok := q.job(w, r); ok {
+		writeJSON(w, r, j.status())
+	}
+}
+
+func (q *jobQueue) results(w http.ResponseWriter, r *http.Request) {
+	j, ok := q.job(w, r)
+	if !ok {
+		return
+	}
+
+	units, err := parseUnitSystem(r.URL.Query().Get("units"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	j.mu.Lock()
+	if j.finished.IsZero() {
+		j.mu.Unlock()
+		http.Error(w, "job not finished", http.StatusConflict)
+		return
+	}
+	results := make([]jobResult, len(j.results))
+	for i, res := range j.results {
+		if res.Temp != nil {
+			t := units.temperature(*res.Temp)
+			res.Temp = &t
+		}
+		results[i] = res
+	}
+	j.mu.Unlock()
+
+	writeJSON(w, r, map[string]interface{}{
+		"id":        j.id,
+		"units":     units,
+		"temp_unit": units.temperatureUnit(),
+		"results":   results,
+	})
+}
+
+func (q *jobQueue) create(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Cities []string `json:"cities"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		http.Error(w, "invalid job: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.Cities) == 0 || len(req.Cities) > maxJobCities {
+		http.Error(w, fmt.Sprintf("a job needs between 1 and %d cities", maxJobCities), http.StatusBadRequest)
+		return
+	}
+	for _, city := range req.Cities {
+		if err := validCity(city); err != nil {
+			http.Error(w, fmt.Sprintf("invalid job: %q: %v", city, err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	j, err := q.submit(req.Cities)
+	if err != nil {
+		w.Header().Set("Retry-After", "60")
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Location", apiVersion+"/jobs/"+j.id)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(j.status())
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	jwksRefresh  = time.Hour
+	jwksMinFetch = time.Minute // at most one fetch per minute for unknown kids
+	jwksTimeout  = 10 * time.Second
+	jwtLeeway    = time.Minute // clock skew allowed on exp and nbf
+)
+
+// jwtConfig is the auth = "jwt" part of the config.
+type jwtConfig struct {
+	issuer   string
+	audience string
+	jwksURL  string
+}
+
+// jwks holds the signing keys published at an identity provider's JWKS
+// URL, by key ID. Keys are refetched every jwksRefresh, and early when a
+// token names a key we have not seen, which is how rotations show up.
+// Concurrent requests share one fetch, made outside the lock, and a failed
+// fetch keeps the keys we had.
+type jwks struct {
+	url     string
+	flights flightGroup
+
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetched   time.Time // last successful fetch
+	attempted time.Time // last fetch, successful or not
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	b := func(s string) *big.Int {
+		raw, _ := base64.RawURLEncoding.DecodeString(s)
+		return new(big.Int).SetBytes(raw)
+	}
+	switch k.Kty {
+	case "RSA":
+		if k.N == "" || k.E == "" {
+			return nil, fmt.Errorf("RSA key without n or e")
+		}
+		return &rsa.PublicKey{N: b(k.N), E: int(b(k.E).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: b(k.X), Y: b(k.Y)}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func (s *jwks) fetch(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", s.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := upstreamClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("JWKS: %s", resp.Status)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("JWKS: %v", err)
+	}
+	keys := map[string]crypto.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if pub, err := k.publicKey(); err == nil {
+			keys[k.Kid] = pub
+		}
+	}
+	return keys, nil
+}
+
+// refresh fetches the key set once for all concurrent callers. The fetch
+// is detached from the request that started it and bounded by
+// jwksTimeout, so a cancelled request cannot fail it for the others.
+func (s *jwks) refresh(ctx context.Context) error {
+	v, err := s.flights.do(ctx, "jwks", func(ctx context.Context) interface{} {
+		ctx, cancel := context.WithTimeout(ctx, jwksTimeout)
+		defer cancel()
+		keys, err := s.fetch(ctx)
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.attempted = time.Now()
+		if err != nil {
+			return err
+		}
+		s.keys, s.fetched = keys, s.attempted
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if v != nil {
+		return v.(error)
+	}
+	return nil
+}
+
+// key returns the public key with the given ID.
+func (s *jwks) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	s.mu.Lock()
+	_, known := s.keys[kid]
+	stale := time.Since(s.fetched) > jwksRefresh
+	due := s.keys == nil || time.Since(s.attempted) > jwksMinFetch
+	s.mu.Unlock()
+
+	var err error
+	if (stale || !known) && due {
+		err = s.refresh(ctx)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if k, ok := s.keys[kid]; ok {
+		return k, nil
+	}
+	if s.keys == nil && err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("unknown key %q", kid)
+}
+
+// jwtAlgs maps the supported JWS algorithms to their hash. HS* and
+// "none" are deliberately missing: only the identity provider can sign.
+var jwtAlgs = map[string]crypto.Hash{
+	"RS256": crypto.SHA256, "RS384": crypto.SHA384, "RS512": crypto.SHA512,
+	"ES256": crypto.SHA256, "ES384": crypto.SHA384, "ES512": crypto.SHA512,
+}
+
+// audience is the aud claim, which may be a string or a list.
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var one string
+	if json.Unmarshal(b, &one) == nil {
+		*a = audience{one}
+		return nil
+	}
+	return json.Unmarshal(b, (*[]string)(a))
+}
+
+type jwtClaims struct {
+	Issuer    string   `json:"iss"`
+	Subject   string   `json:"sub"`
+	Audience  audience `json:"aud"`
+	Expires   *int64   `json:"exp"`
+	NotBefore *int64   `json:"nbf"`
+}
+
+func verifySignature(alg string, pub crypto.PublicKey, signed, sig []byte) error {
+	hash := jwtAlgs[alg]
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		if alg[0] != 'R' {
+			break
+		}
+		if rsa.VerifyPKCS1v15(pub, hash, digest, sig) == nil {
+			return nil
+		}
+		return errors.New("bad signature")
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if alg[0] != 'E' || len(sig) != 2*size {
+			break
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if ecdsa.Verify(pub, digest, r, s) {
+			return nil
+		}
+		return errors.New("bad signature")
+	}
+	return fmt.Errorf("key does not match %s", alg)
+}
+
+// jwtAuth admits requests bearing a JWT signed by a key from the JWKS,
+// issued by issuer for audience and currently valid, and public paths.
+type jwtAuth struct {
+	cfg  jwtConfig
+	keys *jwks
+	next http.Handler
+}
+
+func newJWTAuth(cfg jwtConfig, next http.Handler) jwtAuth {
+	return jwtAuth{cfg: cfg, keys: &jwks{url: cfg.jwksURL}, next: next}
+}
+
+func (a jwtAuth) verify(ctx context.Context, token string) (jwtClaims, error) {
+	var claims jwtClaims
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return claims, errors.New("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(raw, &header) != nil {
+		return claims, errors.New("malformed token header")
+	}
+	if _, ok := jwtAlgs[header.Alg]; !ok {
+		return claims, fmt.Errorf("unsupported algorithm %q", header.Alg)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return claims, errors.New("malformed token signature")
+	}
+
+	pub, err := a.keys.key(ctx, header.Kid)
+	if err != nil {
+		return claims, err
+	}
+	if err := verifySignature(header.Alg, pub, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return claims, err
+	}
+
+	raw, err = base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || json.Unmarshal(raw, &claims) != nil {
+		return claims, errors.New("malformed token claims")
+	}
+	now := time.Now()
+	switch {
+	case claims.Expires == nil:
+		return claims, errors.New("token has no expiry")
+	case now.After(time.Unix(*claims.Expires, 0).Add(jwtLeeway)):
+		return claims, errors.New("token expired")
+	case claims.NotBefore != nil && now.Before(time.Unix(*claims.NotBefore, 0).Add(-jwtLeeway)):
+		return claims, errors.New("token not yet valid")
+	case a.cfg.issuer != "" && claims.Issuer != a.cfg.issuer:
+		return claims, fmt.Errorf("wrong issuer %q", claims.Issuer)
+	}
+	if a.cfg.audience != "" {
+		for _, aud := range claims.Audience {
+			if aud == a.cfg.audience {
+				return claims, nil
+			}
+		}
+		return claims, errors.New("wrong audience")
+	}
+	return claims, nil
+}
+
+func (a jwtAuth) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !publicPath(r.URL.Path) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "missing bearer token", http.StatusUnauthorized)
+			return
+		}
+		if _, err := a.verify(r.Context(), token); err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			http.Error(w, "invalid token: "+err.Error(), http.StatusUnauthorized)
+			return
+		}
+	}
+	a.next.ServeHTTP(w, r)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	leaderKey  = redisKeyPrefix + "leader"
+	watchesKey = redisKeyPrefix + "watches"
+	leaderTTL  = 15 * time.Second
+)
+
+// The lock is only renewed or released by the instance holding it, which
+// takes a compare and a write in one step.
+const (
+	renewScript  = `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("PEXPIRE", KEYS[1], ARGV[2]) end return 0`
+	resignScript = `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("DEL", KEYS[1]) end return 0`
+)
+
+// cluster coordinates the instances that share a Redis cache, so that
+// adding instances does not multiply upstream calls. They elect a leader
+// by holding a lock that expires unless renewed, and only the leader runs
+// the background work: refreshing every watched report into the shared
+// cache, and the contract check. The others read refreshed reports from
+// the cache. Watched reports are registered in a sorted set scored by
+// when they lapse, so the leader also refreshes the watches of others.
+type cluster struct {
+	redis   *redisCache
+	id      string
+	leading atomic.Bool
+}
+
+// watchSpec is a watched report as registered for the leader.
+type watchSpec struct {
+	City         string     `json:"city"`
+	Country      string     `json:"country,omitempty"`
+	Coord        *Coord     `json:"coord,omitempty"`
+	Units        unitSystem `json:"units"`
+	Aggregator   string     `json:"aggregator"`
+	MinProviders int        `json:"min_providers"`
+	OutlierDelta float64    `json:"outlier_delta,omitempty"`
+	OutlierSigma float64    `json:"outlier_sigma,omitempty"`
+}
+
+// newCluster returns the cluster of instances sharing store, or nil when
+// store is not shared: a lone instance always leads.
+func newCluster(store Cache) *cluster {
+	rc, ok := store.(*redisCache)
+	if !ok {
+		return nil
+	}
+	host, _ := os.Hostname()
+	b := make([]byte, 8)
+	rand.Read(b)
+	cl := &cluster{redis: rc, id: host + "-" + hex.EncodeToString(b)}
+	cl.elect()
+	return cl
+}
+
+// leader reports whether this instance should run the background work.
+func (cl *cluster) leader() bool {
+	return cl == nil || cl.leading.Load()
+}
+
+// campaign takes or renews the lock every third of its lifetime until
+// shutdown, then releases it so that another instance takes over at once.
+// The first attempt is made by newCluster.
+func (cl *cluster) campaign() {
+	ticker := time.NewTicker(leaderTTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			cl.elect()
+		case <-shuttingDown:
+			if cl.leading.Load() {
+				cl.redis.do("EVAL", resignScript, "1", leaderKey, cl.id)
+			}
+			return
+		}
+	}
+}
+
+func (cl *cluster) elect() {
+	ms := strconv.FormatInt(leaderTTL.Milliseconds(), 10)
+	var v interface{}
+	var err error
+	if cl.leading.Load() {
+		v, err = cl.redis.do("EVAL", renewScript, "1", leaderKey, cl.id, ms)
+	} else {
+		v, err = cl.redis.do("SET", leaderKey, cl.id, "NX", "PX", ms)
+	}
+	// A lock that cannot be renewed may already be another's: step down
+	// rather than risk two leaders.
+	leading := err == nil && (v == "OK" || v == int64(1))
+	if err != nil {
+		slog.Warn("redis", "op", "leader election", "error", err)
+	}
+	if cl.leading.Swap(leading) != leading {
+		slog.Info("leadership changed", "instance", cl.id, "leader", leading)
+	}
+}
+
+// watch registers a watched report for the leader to refresh until ttl
+// from now.
+func (cl *cluster) watch(loc Location, units unitSystem, pol policy, ttl time.Duration) {
+	b, err := json.Marshal(watchSpec{
+		City: loc.City, Country: loc.Country, Coord: loc.Coord, Units: units,
+		Aggregator: pol.aggregator.name(), MinProviders: pol.minProviders,
+		OutlierDelta: pol.outlierDelta, OutlierSigma: pol.outlierSigma,
+	})
+	if err != nil {
+		return
+	}
+	expires := strconv.FormatInt(time.Now().Add(ttl).UnixMilli(), 10)
+	if _, err := cl.redis.do("ZADD", watchesKey, expires, string(b)); err != nil {
+		slog.Warn("redis", "op", "ZADD", "key", watchesKey, "error", err)
+	}
+}
+
+// watched returns the reports watched by any instance, dropping lapsed
+// ones.
+func (cl *cluster) watched() ([]watchSpec, error) {
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	if _, err := cl.redis.do("ZREMRANGEBYSCORE", watchesKey, "-inf", "("+now); err != nil {
+		return nil, err
+	}
+	v, err := cl.redis.do("ZRANGE", watchesKey, "0", "-1")
+	if err != nil {
+		return nil, err
+	}
+	items, _ := v.([]interface{})
+	specs := make([]watchSpec, 0, len(items))
+	for _, item := range items {
+		b, _ := item.([]byte)
+		var s watchSpec
+		if err := json.Unmarshal(b, &s); err != nil {
+			continue
+		}
+		specs = append(specs, s)
+	}
+	return specs, nil
+}
+
+// target returns the location, units and policy of a registered watch.
+func (s watchSpec) target() (Location, unitSystem, policy, error) {
+	agg, err := parseAggregator(s.Aggregator)
+	if err != nil {
+		return Location{}, "", policy{}, err
+	}
+	pol := policy{minProviders: s.MinProviders, aggregator: agg, outlierDelta: s.OutlierDelta, outlierSigma: s.OutlierSigma}
+	return Location{City: s.City, Country: s.Country, Coord: s.Coord}, s.Units, pol, nil
+}
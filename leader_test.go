@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis answers the commands the cluster sends, with the scripts
+// evaluated by what they do rather than by Lua.
+type fakeRedis struct {
+	mu      sync.Mutex
+	strings map[string]string
+	zset    map[string]float64
+}
+
+func startFakeRedis(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	f := &fakeRedis{strings: map[string]string{}, zset: map[string]float64{}}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	rd := bufio.NewReader(conn)
+	for {
+		v, err := readRESP(rd)
+		if err != nil {
+			return
+		}
+		items := v.([]interface{})
+		args := make([]string, len(items))
+		for i, item := range items {
+			args[i] = string(item.([]byte))
+		}
+		f.mu.Lock()
+		reply := f.command(args)
+		f.mu.Unlock()
+		fmt.Fprint(conn, reply)
+	}
+}
+
+func (f *fakeRedis) command(args []string) string {
+	switch args[0] {
+	case "SET":
+		if _, held := f.strings[args[1]]; held {
+			return "$-1\r\n"
+		}
+		f.strings[args[1]] = args[2]
+		return "+OK\r\n"
+	case "EVAL":
+		if f.strings[args[3]] != args[4] {
+			return ":0\r\n"
+		}
+		if args[1] == resignScript {
+			delete(f.strings, args[3])
+		}
+		return ":1\r\n"
+	case "ZADD":
+		f.zset[args[3]], _ = strconv.ParseFloat(args[2], 64)
+		return ":1\r\n"
+	case "ZREMRANGEBYSCORE":
+		max, _ := strconv.ParseFloat(strings.TrimPrefix(args[3], "("), 64)
+		for m, score := range f.zset {
+			if score < max {
+				delete(f.zset, m)
+			}
+		}
+		return ":0\r\n"
+	case "ZRANGE":
+		var members []string
+		for m := range f.zset {
+			members = append(members, m)
+		}
+		sort.Slice(members, func(i, j int) bool { return f.zset[members[i]] < f.zset[members[j]] })
+		var b strings.Builder
+		fmt.Fprintf(&b, "*%d\r\n", len(members))
+		for _, m := range members {
+			fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(m), m)
+		}
+		return b.String()
+	}
+	return "-ERR unknown command\r\n"
+}
+
+func TestClusterElectsOneLeader(t *testing.T) {
+	addr := startFakeRedis(t)
+	a, b := newCluster(&redisCache{addr: addr}), newCluster(&redisCache{addr: addr})
+	if !a.leader() || b.leader() {
+		t.Fatalf("leaders %v, %v, want only the first", a.leader(), b.leader())
+	}
+
+	a.elect()
+	b.elect()
+	if !a.leader() || b.leader() {
+		t.Fatalf("after renewal leaders %v, %v", a.leader(), b.leader())
+	}
+
+	a.redis.do("EVAL", resignScript, "1", leaderKey, a.id)
+	a.leading.Store(false)
+	b.elect()
+	if !b.leader() {
+		t.Errorf("the second instance did not take over")
+	}
+
+	var lone *cluster
+	if !lone.leader() {
+		t.Errorf("an instance without a cluster does not lead")
+	}
+}
+
+func TestClusterWatches(t *testing.T) {
+	cl := newCluster(&redisCache{addr: startFakeRedis(t)})
+	pol := policy{minProviders: 1, aggregator: medianAggregator{}}
+	cl.watch(Location{City: "Oslo", Country: "NO"}, metric, pol, time.Minute)
+	cl.watch(Location{City: "Oslo", Country: "NO"}, metric, pol, time.Minute)
+	cl.watch(Location{City: "Lima"}, imperial, pol, -time.Minute)
+
+	specs, err := cl.watched()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(specs) != 1 {
+		t.Fatalf("%d watches, want 1: %+v", len(specs), specs)
+	}
+	loc, units, got, err := specs[0].target()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loc.String() != "Oslo,NO" || units != metric || got.aggregator.name() != "median" || got.minProviders != 1 {
+		t.Errorf("watch %+v %s %+v", loc, units, got)
+	}
+}
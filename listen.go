@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// socketMode is the permission of a Unix socket we create: the owner and
+// its group, such as the proxy's, may connect.
+const socketMode = 0660
+
+// openListener listens on a TCP address, or on a Unix socket given as
+// "unix:/path". A socket file left behind by an earlier run is replaced;
+// the socket is removed again when the listener is closed on shutdown.
+func openListener(addr string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, "unix:")
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		// Only remove it if nothing answers there any more.
+		if c, err := net.Dial("unix", path); err == nil {
+			c.Close()
+			return nil, fmt.Errorf("%s is in use by another process", path)
+		}
+		os.Remove(path)
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, socketMode); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// listenFdsStart is the first file descriptor systemd passes.
+const listenFdsStart = 3
+
+// inheritedListener returns the socket systemd passed us under socket
+// activation (LISTEN_PID and LISTEN_FDS), or nil if there is none. It
+// stays open in systemd across restarts, so connections queue instead of
+// being refused while we restart.
+func inheritedListener() (net.Listener, error) {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("LISTEN_FDS: want a positive number, got %q", os.Getenv("LISTEN_FDS"))
+	}
+	if n > 1 {
+		return nil, fmt.Errorf("LISTEN_FDS: got %d sockets, want one", n)
+	}
+	// Not for our children.
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	syscall.CloseOnExec(listenFdsStart)
+	f := os.NewFile(listenFdsStart, "systemd socket")
+	defer f.Close()
+	return net.FileListener(f)
+}
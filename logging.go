@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// contextHandler adds the request ID of a record's context as a field.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := requestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// setupLogging makes the default slog logger, which the log package also
+// writes through, emit format ("text" or "json") to stderr at level and
+// above. The log package writes at error level, so log.Fatal messages
+// are never filtered out.
+func setupLogging(format, level string) error {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("-log-level: want debug, info, warn or error")
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	var h slog.Handler
+	switch strings.ToLower(format) {
+	case "text":
+		h = slog.NewTextHandler(os.Stderr, opts)
+	case "json":
+		h = slog.NewJSONHandler(os.Stderr, opts)
+	default:
+		return fmt.Errorf("-log-format: want text or json")
+	}
+	slog.SetDefault(slog.New(contextHandler{h}))
+	slog.SetLogLoggerLevel(slog.LevelError)
+	return nil
+}
+
+// logReading logs the temperature a provider reported for loc.
+func logReading(ctx context.Context, provider string, loc Location, celsius float64) {
+	slog.InfoContext(ctx, "reading", "provider", provider, "city", loc.String(), "temp", celsius)
+}
+
+// logUpstream logs one attempt at an upstream request at debug level. Only
+// the host is logged since paths and queries can hold API keys.
+func logUpstream(ctx context.Context, req *http.Request, attempt int, resp *http.Response, err error, latency time.Duration) {
+	if !slog.Default().Enabled(ctx, slog.LevelDebug) {
+		return
+	}
+	attrs := []any{"host", req.URL.Host, "attempt", attempt, "latency", latency}
+	if provider, _ := ctx.Value(providerKey{}).(string); provider != "" {
+		attrs = append(attrs, "provider", provider)
+	}
+	if err != nil {
+		attrs = append(attrs, "error", publicError(err))
+	} else {
+		attrs = append(attrs, "status", resp.StatusCode)
+	}
+	slog.DebugContext(ctx, "upstream", attrs...)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const maxWaitTimeout = 5 * time.Minute
+
+// waitReport is the body of a /weather/{city}/wait response.
+type waitReport struct {
+	weatherReport
+	Previous float64 `json:"previous"`
+	Changed  bool    `json:"changed"`
+}
+
+// waitForChange serves /weather/{city}/wait and /weather/wait?lat=&lon=:
+// it holds the request until the temperature has moved by at least
+// ?delta= (default 1.0, in the requested units) or ?timeout= (default
+// 60s) has passed. The reference is ?temp= when the client already has a
+// reading, otherwise the first reading taken here. Pending waits are
+// answered as unchanged when the server shuts down. Readings come from
+// the refresher, so waiting clients share one lookup per refresh
+// interval with /v1/stream and the event streams.
+func waitForChange(rf *refresher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		begin := time.Now()
+		q := r.URL.Query()
+
+		loc, err := parseLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		units, pol, err := reportOptions(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		delta := 1.0
+		if v := q.Get("delta"); v != "" {
+			if delta, err = strconv.ParseFloat(v, 64); err != nil || delta <= 0 {
+				http.Error(w, "delta must be a positive number", http.StatusBadRequest)
+				return
+			}
+		}
+
+		timeout := 60 * time.Second
+		if v := q.Get("timeout"); v != "" {
+			if timeout, err = time.ParseDuration(v); err != nil || timeout <= 0 || timeout > maxWaitTimeout {
+				http.Error(w, fmt.Sprintf("timeout must be a duration up to %s", maxWaitTimeout), http.StatusBadRequest)
+				return
+			}
+		}
+
+		var previous float64
+		haveReference := false
+		if v := q.Get("temp"); v != "" {
+			if previous, err = strconv.ParseFloat(v, 64); err != nil {
+				http.Error(w, "temp must be a number", http.StatusBadRequest)
+				return
+			}
+			haveReference = true
+		}
+
+		// The wait outlives any -write-timeout.
+		http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+		updates, cancel := rf.subscribe(loc, units, pol)
+		defer cancel()
+		deadline := time.NewTimer(timeout)
+		defer deadline.Stop()
+
+		var latest *weatherReport
+		changed := false
+	wait:
+		for {
+			select {
+			case u := <-updates:
+				latest = &u.report
+				if !haveReference {
+					previous, haveReference = latest.Temp, true
+				}
+				if changed = math.Abs(latest.Temp-previous) >= delta; changed {
+					break wait
+				}
+			case <-r.Context().Done():
+				return
+			case <-deadline.C:
+				break wait
+			case <-shuttingDown:
+				break wait
+			}
+		}
+
+		if latest == nil {
+			http.Error(w, "no reading before the timeout", http.StatusGatewayTimeout)
+			return
+		}
+		report := *latest
+		report.Took = time.Since(begin).String()
+		writeJSON(w, r, waitReport{weatherReport: report, Previous: previous, Changed: changed})
+	}
+}
@@ -1,31 +1,117 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
+	"log/slog"
+	"math"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 type weatherProvider interface {
-	temperature(city string) (float64, error) // in Kelvin
+	temperature(ctx context.Context, loc Location) (float64, error) // in Celsius
+}
+
+// errNotCovered is returned by regional providers for locations outside
+// their area. Such providers are left out of the aggregation instead of
+// counting as failed.
+var errNotCovered = errors.New("location not covered")
+
+// coordinateProvider is implemented by providers that can look up a
+// temperature directly by coordinates, without geocoding a city name.
+type coordinateProvider interface {
+	temperatureAt(ctx context.Context, coord Coord) (float64, error)
 }
 
 type Coord struct {
 	Lon float64
 	Lat float64
 }
+
+// Location is what a lookup is for: a city name, optionally narrowed to
+// an ISO 3166-1 alpha-2 country, or coordinates when Coord is set, in
+// which case providers skip geocoding.
+type Location struct {
+	City    string
+	Country string
+	Coord   *Coord
+}
+
+// query is the city as geocoders take it: "name" or "name,CC".
+func (l Location) query() string {
+	if l.Country != "" {
+		return l.City + "," + l.Country
+	}
+	return l.City
+}
+
+// String names the location in logs, cache keys and responses;
+// coordinates read "lat,lon".
+func (l Location) String() string {
+	if l.Coord != nil {
+		return FloatToString(l.Coord.Lat) + "," + FloatToString(l.Coord.Lon)
+	}
+	return l.query()
+}
+
 type multiWeatherProvider []weatherProvider
-type openWeatherMap struct{}
-type weatherUnderground struct {
+type openWeatherMap struct {
 	apiKey string
 }
-type forecastIo struct {
+type weatherUnderground struct {
 	apiKey string
 }
 
+func init() {
+	RegisterProvider("openWeatherMap", func(pc *providerConfig) (weatherProvider, error) {
+		if pc.apiKey == "" {
+			return nil, errNoAPIKey
+		}
+		return openWeatherMap{apiKey: pc.apiKey}, nil
+	})
+	RegisterProvider("weatherUnderground", func(pc *providerConfig) (weatherProvider, error) {
+		if pc.apiKey == "" {
+			return nil, errNoAPIKey
+		}
+		return weatherUnderground{apiKey: pc.apiKey}, nil
+	})
+}
+
+// weatherReport is the body of a /weather/ response. Its exported field
+// names are also what user-supplied templates refer to, e.g.
+// "{{.City}}: {{.Temp}}".
+type weatherReport struct {
+	City       string             `json:"city,omitempty"`
+	Country    string             `json:"country,omitempty"` // as resolved by the geocoder
+	Coord      *Coord             `json:"coord,omitempty"`   // when looked up by coordinates
+	Temp       float64            `json:"temp"`
+	Units      unitSystem         `json:"units"`
+	TempUnit   string             `json:"temp_unit"`
+	Aggregator string             `json:"aggregator"`
+	Weights    map[string]float64 `json:"weights,omitempty"`
+	Failed     []providerFailure  `json:"failed,omitempty"`
+	Discarded  []discardedReading `json:"discarded,omitempty"`
+	Sources    []sourceReport     `json:"sources,omitempty"`
+	Cached     bool               `json:"cached"`
+	Stale      bool               `json:"stale,omitempty"` // served because every provider failed
+	Age        string             `json:"age,omitempty"`   // of a cached report
+	Took       string             `json:"took"`
+}
+
 func FloatToString(input_num float64) string {
 	return strconv.FormatFloat(input_num, 'f', 2, 64)
 }
@@ -35,82 +121,159 @@ func FahrenheitToCelsius(input_num float64) float64 {
 	return result
 }
 
-func (w forecastIo) temperature(city string) (float64, error) {
-	coord, err := openWeatherMap{}.coordinates(city)
-	if err != nil {
-		return 0, err
-	}
+func (w openWeatherMap) url(city string) string {
+	return baseURL("openWeatherMap") + "/data/2.5/weather?q=" + url.QueryEscape(city) + "&appid=" + url.QueryEscape(w.apiKey)
+}
 
-	url := "https://api.forecast.io/forecast/" + w.apiKey + "/" + FloatToString(coord.Lat) + "," + FloatToString(coord.Lon)
+func (w openWeatherMap) coordURL(coord Coord) string {
+	return baseURL("openWeatherMap") + "/data/2.5/weather?lat=" + FloatToString(coord.Lat) + "&lon=" + FloatToString(coord.Lon) + "&appid=" + url.QueryEscape(w.apiKey)
+}
 
-	resp, err := http.Get(url)
+// geocode resolves a city query to the place OpenWeatherMap's weather
+// endpoint picks for it.
+func (w openWeatherMap) geocode(ctx context.Context, query string) (place, error) {
+	resp, err := httpGet(ctx, w.url(query))
 	if err != nil {
-		return 0, err
+		return place{}, err
 	}
 
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return place{}, statusError{resp.StatusCode, resp.Status}
+	}
 
 	var d struct {
-		Currently struct {
-			Fahrenheit float64 `json:"temperature"`
-		} `json:"currently"`
+		Name  string `json:"name"`
+		Coord *struct {
+			Lon float64 `json:"lon"`
+			Lat float64 `json:"lat"`
+		} `json:"coord"`
+		Sys struct {
+			Country string `json:"country"`
+		} `json:"sys"`
 	}
 
 	if err := json.NewDecoder(resp.Body).Decode(&d); err != nil {
+		return place{}, err
+	}
+	if d.Coord == nil {
+		return place{}, fmt.Errorf("openWeatherMap: no match")
+	}
+
+	p := place{City: d.Name, Country: d.Sys.Country, Coord: Coord{d.Coord.Lon, d.Coord.Lat}}
+	if p.City == "" {
+		p.City, _, _ = strings.Cut(query, ",")
+	}
+	return p, nil
+}
+
+func (w openWeatherMap) temperature(ctx context.Context, loc Location) (float64, error) {
+	url := w.url(loc.query())
+	if loc.Coord != nil {
+		url = w.coordURL(*loc.Coord)
+	}
+
+	celsius, err := w.fetchTemperature(ctx, url)
+	if err != nil {
 		return 0, err
 	}
 
-	log.Printf("forecastIo: %s: %.2f", city, FahrenheitToCelsius(d.Currently.Fahrenheit))
-	return FahrenheitToCelsius(d.Currently.Fahrenheit), nil
+	logReading(ctx, "openWeatherMap", loc, celsius)
+	return celsius, nil
+}
+
+func (w openWeatherMap) temperatureAt(ctx context.Context, coord Coord) (float64, error) {
+	return w.fetchTemperature(ctx, w.coordURL(coord))
 }
 
-func (w openWeatherMap) coordinates(city string) (Coord, error) {
-	resp, err := http.Get("http://api.openweathermap.org/data/2.5/weather?q=" + city)
+func (w openWeatherMap) fetchTemperature(ctx context.Context, url string) (float64, error) {
+	resp, err := httpGet(ctx, url)
 	if err != nil {
-		return Coord{}, nil
+		return 0, err
 	}
 
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, statusError{resp.StatusCode, resp.Status}
+	}
 
 	var d struct {
-		Coord struct {
-			Lon float64 `json:"lon"`
-			Lat float64 `json:"lat"`
-		} `json:"coord"`
+		Main struct {
+			Kelvin float64 `json:"temp"`
+		} `json:"main"`
 	}
 
 	if err := json.NewDecoder(resp.Body).Decode(&d); err != nil {
-		return Coord{}, nil
+		return 0, err
 	}
 
-	return Coord{d.Coord.Lon, d.Coord.Lat}, nil
+	return d.Main.Kelvin - 273.15, nil
 }
 
-func (w openWeatherMap) temperature(city string) (float64, error) {
-	resp, err := http.Get("http://api.openweathermap.org/data/2.5/weather?q=" + city)
+func (w openWeatherMap) observe(ctx context.Context, city string) (Observation, error) {
+	resp, err := httpGet(ctx, w.url(city))
 	if err != nil {
-		return 0, err
+		return Observation{}, err
 	}
 
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Observation{}, statusError{resp.StatusCode, resp.Status}
+	}
 
 	var d struct {
 		Main struct {
-			Kelvin float64 `json:"temp"`
+			Kelvin   *float64 `json:"temp"`
+			Humidity *float64 `json:"humidity"`
+			Pressure *float64 `json:"pressure"`
 		} `json:"main"`
+		Wind struct {
+			Speed     *float64 `json:"speed"`
+			Direction *float64 `json:"deg"`
+		} `json:"wind"`
+		Clouds struct {
+			Cover *float64 `json:"all"`
+		} `json:"clouds"`
+		Visibility *float64 `json:"visibility"`
 	}
 
 	if err := json.NewDecoder(resp.Body).Decode(&d); err != nil {
-		return 0, err
+		return Observation{}, err
 	}
 
-	celsius := d.Main.Kelvin - 273.15
-	log.Printf("openWeatherMap: %s: %.2f", city, celsius)
-	return celsius, nil
+	o := Observation{
+		Humidity:      d.Main.Humidity,
+		WindSpeed:     d.Wind.Speed,
+		WindDirection: d.Wind.Direction,
+		Pressure:      d.Main.Pressure,
+		CloudCover:    d.Clouds.Cover,
+	}
+	if d.Main.Kelvin != nil {
+		o.Temp = ptr(*d.Main.Kelvin - 273.15)
+	}
+	if d.Visibility != nil {
+		o.Visibility = ptr(*d.Visibility / 1000)
+	}
+	return o, nil
+}
+
+func (w weatherUnderground) url(city string) string {
+	return baseURL("weatherUnderground") + "/api/" + w.apiKey + "/conditions/q/" + url.PathEscape(city) + ".json"
 }
 
-func (w weatherUnderground) temperature(city string) (float64, error) {
-	resp, err := http.Get("http://api.wunderground.com/api/" + w.apiKey + "/conditions/q/" + city + ".json")
+// temperature queries by city name or, for coordinates, by "lat,lon",
+// which Weather Underground accepts in the same place. It has no notion
+// of country codes, so a city with one is geocoded first.
+func (w weatherUnderground) temperature(ctx context.Context, loc Location) (float64, error) {
+	if loc.Country != "" && loc.Coord == nil {
+		coord, err := locateCoord(ctx, loc.query())
+		if err != nil {
+			return 0, err
+		}
+		loc = Location{Coord: &coord}
+	}
+
+	resp, err := httpGet(ctx, w.url(loc.String()))
 	if err != nil {
 		return 0, err
 	}
@@ -127,47 +290,670 @@ func (w weatherUnderground) temperature(city string) (float64, error) {
 		return 0, err
 	}
 
-	log.Printf("weatherUnderground: %s, %.2f", city, d.Observation.Celsius)
+	logReading(ctx, "weatherUnderground", loc, d.Observation.Celsius)
 	return d.Observation.Celsius, err
 }
 
-func (w multiWeatherProvider) temperature(city string) (float64, error) {
-	temps := make(chan float64, len(w))
-	errs := make(chan error, len(w))
+func (w weatherUnderground) observe(ctx context.Context, city string) (Observation, error) {
+	resp, err := httpGet(ctx, w.url(city))
+	if err != nil {
+		return Observation{}, err
+	}
 
-	for _, provider := range w {
-		go func(p weatherProvider) {
-			k, err := p.temperature(city)
-			if err != nil {
-				errs <- err
-				return
-			}
-			temps <- k
-		}(provider)
+	defer resp.Body.Close()
+
+	var d struct {
+		Observation struct {
+			Celsius       *float64 `json:"temp_c"`
+			Humidity      string   `json:"relative_humidity"`
+			WindSpeed     *float64 `json:"wind_kph"`
+			WindDirection *float64 `json:"wind_degrees"`
+			Pressure      string   `json:"pressure_mb"`
+			Visibility    string   `json:"visibility_km"`
+			UV            string   `json:"UV"`
+		} `json:"current_observation"`
 	}
 
-	sum := 0.0
+	if err := json.NewDecoder(resp.Body).Decode(&d); err != nil {
+		return Observation{}, err
+	}
 
-	for i := 0; i < len(w); i++ {
-		select {
-		case temp := <-temps:
-			sum += temp
+	// Several fields come as strings, and "NA" or "" when unknown.
+	number := func(s string) *float64 {
+		v, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+		if err != nil {
+			return nil
+		}
+		return &v
+	}
+	c := d.Observation
+	o := Observation{
+		Temp:          c.Celsius,
+		Humidity:      number(c.Humidity),
+		WindDirection: c.WindDirection,
+		Pressure:      number(c.Pressure),
+		Visibility:    number(c.Visibility),
+		UVIndex:       number(c.UV),
+	}
+	if c.WindSpeed != nil {
+		o.WindSpeed = ptr(*c.WindSpeed / 3.6)
+	}
+	return o, nil
+}
 
-		case err := <-errs:
-			return 0, err
+func (w weatherUnderground) alerts(ctx context.Context, coord Coord) ([]weatherAlert, error) {
+	resp, err := httpGet(ctx, baseURL("weatherUnderground")+"/api/"+w.apiKey+"/alerts/q/"+FloatToString(coord.Lat)+","+FloatToString(coord.Lon)+".json")
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	var d struct {
+		Alerts []struct {
+			Description  string `json:"description"`
+			Significance string `json:"significance"`
+			Onset        string `json:"date_epoch"`
+			Expires      string `json:"expires_epoch"`
+			Message      string `json:"message"`
+		} `json:"alerts"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&d); err != nil {
+		return nil, err
+	}
+
+	// Significance uses the NWS VTEC letters.
+	severity := map[string]string{"W": "severe", "A": "moderate", "Y": "minor", "S": "minor"}
+	var out []weatherAlert
+	for _, a := range d.Alerts {
+		s, ok := severity[a.Significance]
+		if !ok {
+			s = "unknown"
 		}
+		onset, _ := strconv.ParseInt(a.Onset, 10, 64)
+		expires, _ := strconv.ParseInt(a.Expires, 10, 64)
+		out = append(out, weatherAlert{
+			Source:      "weatherUnderground",
+			Type:        a.Description,
+			Severity:    s,
+			Onset:       unixTime(onset),
+			Expires:     unixTime(expires),
+			Description: strings.TrimSpace(a.Message),
+		})
+	}
+	return out, nil
+}
+
+func (w weatherUnderground) forecast(ctx context.Context, city string, days int) ([]dailyForecast, error) {
+	resp, err := httpGet(ctx, baseURL("weatherUnderground")+"/api/"+w.apiKey+"/forecast10day/q/"+url.PathEscape(city)+".json")
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	var d struct {
+		Forecast struct {
+			Simple struct {
+				Days []struct {
+					Date struct {
+						Year  int `json:"year"`
+						Month int `json:"month"`
+						Day   int `json:"day"`
+					} `json:"date"`
+					High struct {
+						Celsius string `json:"celsius"`
+					} `json:"high"`
+					Low struct {
+						Celsius string `json:"celsius"`
+					} `json:"low"`
+					Icon string `json:"icon"`
+				} `json:"forecastday"`
+			} `json:"simpleforecast"`
+		} `json:"forecast"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&d); err != nil {
+		return nil, err
 	}
 
-	return sum / float64(len(w)), nil
+	var out []dailyForecast
+	for _, day := range d.Forecast.Simple.Days {
+		if len(out) == days {
+			break
+		}
+		high, err := strconv.ParseFloat(day.High.Celsius, 64)
+		if err != nil {
+			return nil, err
+		}
+		low, err := strconv.ParseFloat(day.Low.Celsius, 64)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, dailyForecast{
+			date:      fmt.Sprintf("%04d-%02d-%02d", day.Date.Year, day.Date.Month, day.Date.Day),
+			high:      high,
+			low:       low,
+			condition: normalizeCondition(day.Icon),
+		})
+	}
+	return out, nil
+}
+
+func (w weatherUnderground) hourly(ctx context.Context, city string, hours int) ([]hourlyForecast, error) {
+	resp, err := httpGet(ctx, baseURL("weatherUnderground")+"/api/"+w.apiKey+"/hourly/q/"+url.PathEscape(city)+".json")
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	var d struct {
+		Hours []struct {
+			Time struct {
+				Epoch string `json:"epoch"`
+			} `json:"FCTTIME"`
+			Temp struct {
+				Metric string `json:"metric"`
+			} `json:"temp"`
+			Pop string `json:"pop"`
+		} `json:"hourly_forecast"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&d); err != nil {
+		return nil, err
+	}
+
+	var out []hourlyForecast
+	for _, h := range d.Hours {
+		if len(out) == hours {
+			break
+		}
+		epoch, err := strconv.ParseInt(h.Time.Epoch, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		celsius, err := strconv.ParseFloat(h.Temp.Metric, 64)
+		if err != nil {
+			return nil, err
+		}
+		pop, err := strconv.ParseFloat(h.Pop, 64)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, hourlyForecast{
+			time:          time.Unix(epoch, 0),
+			temp:          celsius,
+			precipitation: pop / 100,
+		})
+	}
+	return out, nil
+}
+
+func (w weatherUnderground) history(ctx context.Context, city string, date time.Time) (dailyForecast, error) {
+	resp, err := httpGet(ctx, baseURL("weatherUnderground")+"/api/"+w.apiKey+"/history_"+date.Format("20060102")+"/q/"+url.PathEscape(city)+".json")
+	if err != nil {
+		return dailyForecast{}, err
+	}
+
+	defer resp.Body.Close()
+
+	var d struct {
+		History struct {
+			Summary []struct {
+				High  string `json:"maxtempm"`
+				Low   string `json:"mintempm"`
+				Fog   string `json:"fog"`
+				Rain  string `json:"rain"`
+				Snow  string `json:"snow"`
+				Storm string `json:"thunder"`
+			} `json:"dailysummary"`
+		} `json:"history"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&d); err != nil {
+		return dailyForecast{}, err
+	}
+	if len(d.History.Summary) == 0 {
+		return dailyForecast{}, fmt.Errorf("weatherUnderground: no data for %s", date.Format("2006-01-02"))
+	}
+
+	day := d.History.Summary[0]
+	high, err := strconv.ParseFloat(day.High, 64)
+	if err != nil {
+		return dailyForecast{}, err
+	}
+	low, err := strconv.ParseFloat(day.Low, 64)
+	if err != nil {
+		return dailyForecast{}, err
+	}
+
+	// The summary only flags notable weather, most significant first.
+	condition := "unknown"
+	for _, c := range []struct{ flag, name string }{
+		{day.Storm, "thunderstorm"}, {day.Snow, "snow"}, {day.Rain, "rain"}, {day.Fog, "fog"},
+	} {
+		if c.flag == "1" {
+			condition = c.name
+			break
+		}
+	}
+	return dailyForecast{date: date.Format("2006-01-02"), high: high, low: low, condition: condition}, nil
+}
+
+// userAgent identifies the service to upstreams; Nominatim, the
+// National Weather Service and MET Norway ask for one.
+const userAgent = "hello_world (+https://github.com/eugene-v/hello_world)"
+
+// httpGet issues a GET request for url that is cancelled with ctx.
+func httpGet(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	if id := requestID(ctx); id != "" {
+		req.Header.Set("X-Request-ID", id)
+	}
+	return guarded(ctx, req)
+}
+
+// providerContext bounds ctx by the configured timeout of provider p and
+// routes its upstream calls through p's circuit breaker.
+func providerContext(ctx context.Context, p interface{}) (context.Context, context.CancelFunc) {
+	ctx = context.WithValue(ctx, providerKey{}, providerName(p))
+	if timeout := providerTimeouts[providerName(p)]; timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// lookups deduplicates concurrent fan-outs for the same location and
+// providers.
+var lookups flightGroup
+
+// collect returns one reading per provider that covers loc. Concurrent
+// calls for the same location share a single round of upstream calls.
+func (w multiWeatherProvider) collect(ctx context.Context, loc Location) []sourceReading {
+	var names []string
+	for _, p := range w {
+		names = append(names, providerName(p))
+	}
+	key := strings.Join(names, ",") + "|" + loc.String()
+
+	v, err := lookups.do(ctx, key, func(ctx context.Context) interface{} {
+		return w.fanOut(ctx, loc)
+	})
+	if err != nil {
+		readings := make([]sourceReading, len(w))
+		for i, p := range w {
+			readings[i] = sourceReading{provider: providerName(p), err: err}
+		}
+		return readings
+	}
+	return v.([]sourceReading)
+}
+
+// fanOut queries all enabled, healthy providers concurrently, each
+// bounded by its configured timeout. Unhealthy providers are probed in
+// the background now and then so that they can recover.
+func (w multiWeatherProvider) fanOut(ctx context.Context, loc Location) []sourceReading {
+	w, excluded := healthyProviders(enabledProviders(w))
+	for _, p := range excluded {
+		if probeDue(providerName(p)) {
+			go func(p weatherProvider) {
+				ctx, cancel := providerContext(ctx, p)
+				defer cancel()
+				begin := time.Now()
+				_, err := p.temperature(ctx, loc)
+				recordHealth(ctx, providerName(p), time.Since(begin), err)
+				recordStats(providerName(p), time.Since(begin), err)
+			}(p)
+		}
+	}
+
+	readings := make([]sourceReading, len(w))
+	done := make(chan struct{}, len(w))
+
+	for i, provider := range w {
+		go func(i int, p weatherProvider) {
+			ctx, cancel := providerContext(ctx, p)
+			defer cancel()
+			ctx, s := startSpan(ctx, "provider "+providerName(p), spanInternal)
+			s.set("provider", providerName(p))
+
+			begin := time.Now()
+			k, err := p.temperature(ctx, loc)
+			readings[i] = sourceReading{provider: providerName(p), temp: k, latency: time.Since(begin), err: err}
+			recordHealth(ctx, providerName(p), readings[i].latency, err)
+			recordStats(providerName(p), readings[i].latency, err)
+			if err == nil {
+				s.set("temp", k)
+			}
+			s.finish(err)
+			done <- struct{}{}
+		}(i, provider)
+	}
+
+	for range w {
+		<-done
+	}
+
+	covered := readings[:0]
+	for _, r := range readings {
+		if !errors.Is(r.err, errNotCovered) {
+			covered = append(covered, r)
+		}
+	}
+	return covered
+}
+
+func (w multiWeatherProvider) temperature(ctx context.Context, loc Location) (float64, error) {
+	c, err := defaultPolicy.aggregate(w.collect(ctx, loc))
+	return c.temp, err
 }
 
 func main() {
+	configPath := flag.String("config", "", "TOML file declaring providers, API keys, timeouts and the listen address")
+	listenFlag := flag.String("listen", "", "listen address, host:port or unix:/path/to.sock, overrides the config file")
+	addr := flag.String("addr", "", "listen host, overrides the config file")
+	port := flag.Int("port", 0, "listen port, overrides the config file")
+	readTimeout := flag.Duration("read-timeout", 10*time.Second, "maximum duration for reading a request")
+	writeTimeout := flag.Duration("write-timeout", 0, "maximum duration for writing a response, 0 for none; long-poll requests and event streams are exempt")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 30*time.Second, "how long to wait for in-flight requests on SIGINT/SIGTERM")
+	fixtures := flag.String("fixtures", "", "serve upstream responses from recorded fixtures in this directory instead of the network")
+	record := flag.String("record", "", "record upstream responses as fixtures into this directory")
+	synthetic := flag.Bool("synthetic", false, "serve generated temperatures instead of querying providers")
+	seed := flag.Int64("synthetic-seed", 1, "seed for -synthetic")
+	dev := flag.Bool("dev", false, "development mode: enables fault injection")
+	faultDelay := flag.Duration("fault-delay", 0, "in -dev mode, delay every response by this much")
+	faultJitter := flag.Duration("fault-jitter", 0, "in -dev mode, add up to this much random delay")
+	faultRate := flag.Float64("fault-error-rate", 0, "in -dev mode, fail this fraction (0..1) of requests")
+	faultStatus := flag.Int("fault-status", http.StatusServiceUnavailable, "in -dev mode, status code of injected failures")
+	contractInterval := flag.Duration("contract-check", 0, "validate upstream payloads against the expected schema this often (0 disables)")
+	contractCity := flag.String("contract-city", "London", "city used for -contract-check, -selfcheck and /readyz")
+	shadow := flag.String("shadow", "", "comma-separated providers to query and log but exclude from the result")
+	canaries := flag.String("canary", "", "comma-separated name:percent pairs of providers aggregated for only that share of requests")
+	experimentSpec := flag.String("experiment", "", "aggregator:percent, such as median:50, to A/B test against the default aggregator on that share of clients")
+	featureSpec := flag.String("features", "", "comma-separated name=on|off feature toggles")
+	selfcheck := flag.Bool("selfcheck", false, "query every provider once, print a pass/fail report and exit")
+	env := flag.String("env", "production", "upstream environment: production or sandbox")
+	logFormat := flag.String("log-format", "text", "log output format: text or json")
+	tlsCert := flag.String("tls-cert", "", "serve HTTPS with this PEM certificate (chain), reloaded when it changes")
+	tlsKey := flag.String("tls-key", "", "PEM private key for -tls-cert")
+	pprofAddr := flag.String("pprof-addr", "", "serve net/http/pprof profiles and /debug/vars on this address, such as localhost:6060; off by default")
+	logLevel := flag.String("log-level", "info", "minimum log level: debug, info, warn or error")
+	flag.Parse()
 
-	http.HandleFunc("/", hello)
-	http.HandleFunc("/coordinates/", coordinates)
-	http.HandleFunc("/weather/", weather)
+	if err := setupLogging(*logFormat, *logLevel); err != nil {
+		log.Fatal(err)
+	}
 
-	http.ListenAndServe(":8080", nil)
+	if *featureSpec != "" {
+		if err := setFeatures(*featureSpec); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	setClientFeatures(cfg.clients)
+
+	switch {
+	case *fixtures != "":
+		upstreamClient.Transport = fixtureTransport{dir: *fixtures}
+	case *record != "":
+		if err := os.MkdirAll(*record, 0755); err != nil {
+			log.Fatal(err)
+		}
+		upstreamClient.Transport = recordingTransport{dir: *record, next: upstreamClient.Transport}
+	}
+
+	var mw multiWeatherProvider
+	if *synthetic {
+		mw = multiWeatherProvider{syntheticWeather{seed: *seed}}
+	} else {
+		mw = cfg.weatherProviders()
+	}
+	if len(mw) == 0 {
+		log.Fatal("no weather provider is enabled")
+	}
+	if err := selectEnvironment(*env, mw); err != nil {
+		log.Fatal(err)
+	}
+
+	if *selfcheck {
+		if !selfCheck(os.Stdout, mw, *contractCity) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	cl := newCluster(cfg.cache)
+	if cl != nil {
+		go cl.campaign()
+	}
+	if *contractInterval > 0 {
+		go watchContracts(mw, *contractCity, *contractInterval, cl)
+	}
+
+	primary, shadows := mw, multiWeatherProvider(nil)
+	if *shadow != "" {
+		shadows, primary, err = partitionProviders(mw, *shadow)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(primary) == 0 {
+			log.Fatal("-shadow leaves no provider to answer requests")
+		}
+	}
+
+	var provider weatherProvider = primary
+	if *canaries != "" {
+		rollout, err := newCanaryRollout(primary, *canaries)
+		if err != nil {
+			log.Fatal(err)
+		}
+		provider = rollout
+	}
+	if len(shadows) > 0 {
+		provider = shadowed{primary: provider, shadows: shadows}
+	}
+	if *experimentSpec != "" {
+		if activeExperiment, err = newExperiment(*experimentSpec); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// The API has a mux of its own, since importing net/http/pprof adds
+	// the profiles to http.DefaultServeMux; they are served by -pprof-addr.
+	mux := http.NewServeMux()
+	reports := newReportCache(cfg.cacheTTL, cfg.staleWindow, cfg.cache)
+	mux.HandleFunc("/{$}", hello)
+	mux.HandleFunc("/healthz", healthz)
+	mux.Handle("/readyz", newReadiness(primary, reports, *contractCity))
+	weatherHandler := withExperiment(weather(provider, reports))
+	refreshes := newRefresher(provider, reports, cfg.refresh, cl)
+	if cl != nil {
+		go refreshes.poll()
+	}
+	route(mux, "GET", "/weather", weatherHandler)
+	route(mux, "GET", "/weather/{city}", weatherHandler)
+	waitHandler := waitForChange(refreshes)
+	route(mux, "GET", "/weather/wait", waitHandler)
+	route(mux, "GET", "/weather/{city}/wait", waitHandler)
+	eventsHandler := events(refreshes)
+	route(mux, "GET", "/weather/events", eventsHandler)
+	route(mux, "GET", "/weather/{city}/events", eventsHandler)
+	batchHandler := batchWeather(provider, reports)
+	route(mux, "GET", "/weather/batch", batchHandler)
+	route(mux, "POST", "/weather/batch", batchHandler)
+	route(mux, "GET", "/compare", compare(provider, reports))
+	route(mux, "GET", "/stream", stream(refreshes))
+	jobs := newJobQueue(provider, reports)
+	route(mux, "POST", "/jobs/{$}", http.HandlerFunc(jobs.create))
+	route(mux, "GET", "/jobs/{id}", http.HandlerFunc(jobs.status))
+	route(mux, "GET", "/jobs/{id}/results", http.HandlerFunc(jobs.results))
+	route(mux, "GET", "/grid", newGrid(mw))
+
+	// City endpoints take the city as ?city=, the canonical form, or as
+	// the last path segment.
+	forecastHandler := forecast(mw)
+	for path, h := range map[string]http.Handler{
+		"/coordinates": http.HandlerFunc(coordinates),
+		"/forecast":    forecastHandler,
+		"/conditions":  conditions(mw),
+		"/history":     history(mw),
+		"/alerts":      alerts(mw),
+		"/airquality":  newAirQuality(cfg.apiKey("openWeatherMap")),
+	} {
+		route(mux, "GET", path, h)
+		route(mux, "GET", path+"/{city}", h)
+	}
+	route(mux, "GET", "/forecast/hourly", forecastHandler)
+	route(mux, "GET", "/forecast/{city}/hourly", forecastHandler)
+	route(mux, "GET", "/tiles/{layer}/{z}/{x}/{y}", newTileProxy(cfg.apiKey("openWeatherMap")))
+
+	var reverseBackends []reverseGeocoder
+	if cfg.cityDB != nil {
+		reverseBackends = append(reverseBackends, cfg.cityDB)
+	}
+	if key := cfg.apiKey("openWeatherMap"); key != "" {
+		reverseBackends = append(reverseBackends, owmGeocoder{apiKey: key})
+	}
+	route(mux, "GET", "/reverse", reverse(append(reverseBackends, nominatim{})))
+	mux.Handle("/admin/", admin{token: cfg.adminToken, mw: mw})
+	route(mux, "GET", "/stats/providers", providerStatsHandler(mw))
+
+	var handler http.Handler = routed{mux: mux}
+	if cfg.rateLimit > 0 || len(cfg.clients) > 0 {
+		handler = newRateLimiter(cfg.rateLimit, cfg.rateBurst, cfg.clients, handler)
+	}
+	switch cfg.auth {
+	case "api_key":
+		handler = newAPIKeyAuth(cfg.clients, handler)
+	case "jwt":
+		handler = newJWTAuth(cfg.jwt, handler)
+	}
+	if cfg.compress {
+		handler = compressor{next: handler}
+	}
+	if len(cfg.cors.origins) > 0 {
+		handler = cors{cfg: cfg.cors, next: handler}
+	}
+	handler = recoverer{next: handler}
+	if cfg.otlpEndpoint != "" {
+		service := os.Getenv("OTEL_SERVICE_NAME")
+		if service == "" {
+			service = "hello_world"
+		}
+		startTracing(cfg.otlpEndpoint, service)
+		handler = traced{next: handler}
+	}
+	if cfg.accessLog != "off" {
+		handler = newAccessLog(cfg.accessLog, os.Stdout, handler)
+	}
+	handler = requestIDs{next: handler}
+	if *dev {
+		handler = faultInjector{
+			delay:     *faultDelay,
+			jitter:    *faultJitter,
+			errorRate: *faultRate,
+			status:    *faultStatus,
+			next:      handler,
+		}
+	}
+
+	if *listenFlag != "" {
+		cfg.listen = *listenFlag
+	}
+	listen, err := listenAddress(cfg.listen, *addr, *port)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *pprofAddr != "" {
+		go servePprof(*pprofAddr)
+	}
+
+	tlsConfig, err := serverTLS(*tlsCert, *tlsKey)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	srv := &http.Server{
+		Addr:         listen,
+		Handler:      handler,
+		ReadTimeout:  *readTimeout,
+		WriteTimeout: *writeTimeout,
+		TLSConfig:    tlsConfig,
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	ln, err := inheritedListener()
+	switch {
+	case err != nil:
+		log.Fatal(err)
+	case ln != nil:
+		slog.Info("using socket passed by systemd", "addr", ln.Addr().String())
+	default:
+		if ln, err = openListener(listen); err != nil {
+			log.Fatal(err)
+		}
+	}
+	errc := make(chan error, 1)
+	go func() {
+		if tlsConfig != nil {
+			errc <- srv.ServeTLS(ln, "", "")
+		} else {
+			errc <- srv.Serve(ln)
+		}
+	}()
+
+	select {
+	case err := <-errc:
+		log.Fatal(err)
+	case <-ctx.Done():
+	}
+
+	slog.Info("shutting down", "timeout", *shutdownTimeout)
+	close(shuttingDown)
+
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		slog.Error("shutdown", "error", err)
+	}
+}
+
+// shuttingDown is closed when the server starts shutting down, so that
+// handlers holding requests open can answer early.
+var shuttingDown = make(chan struct{})
+
+// listenAddress applies the -addr and -port overrides to the listen
+// address from the config. They do not apply to Unix sockets.
+func listenAddress(listen, host string, port int) (string, error) {
+	if strings.HasPrefix(listen, "unix:") {
+		if host != "" || port != 0 {
+			return "", fmt.Errorf("-addr and -port do not apply to %s", listen)
+		}
+		return listen, nil
+	}
+	h, p, err := net.SplitHostPort(listen)
+	if err != nil {
+		return "", fmt.Errorf("invalid listen address %q: %v", listen, err)
+	}
+	if host != "" {
+		h = host
+	}
+	if port != 0 {
+		p = strconv.Itoa(port)
+	}
+	return net.JoinHostPort(h, p), nil
 }
 
 func hello(w http.ResponseWriter, r *http.Request) {
@@ -175,40 +961,264 @@ func hello(w http.ResponseWriter, r *http.Request) {
 }
 
 func coordinates(w http.ResponseWriter, r *http.Request) {
-	city := strings.SplitN(r.URL.Path, "/", 3)[2]
+	loc, err := requestLocation(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	format, err := parseFormat(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	lat, err := openWeatherMap{}.coordinates(city)
+	p, err := locate(r.Context(), loc.query())
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, publicError(err), http.StatusInternalServerError)
+		return
+	}
+	lat := p.Coord
+
+	// Coordinates are cached for geocodeTTL; a day keeps clients from
+	// holding on to a correction for too long.
+	if notModified(w, r, etag(FloatToString(lat.Lat), FloatToString(lat.Lon), r.URL.Path, r.URL.RawQuery), 24*time.Hour) {
 		return
 	}
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"city": city,
-		"temp": lat,
+	w = uncacheableErrors{w}
+
+	if format == "geojson" {
+		writeGeoJSON(w, r, lat, map[string]interface{}{"city": loc.City, "country": p.Country})
+		return
+	}
+	writeJSON(w, r, map[string]interface{}{
+		"city":    loc.City,
+		"country": p.Country,
+		"temp":    lat,
 	})
 }
 
-func weather(w http.ResponseWriter, r *http.Request) {
-	mw := multiWeatherProvider{
-		openWeatherMap{},
-		weatherUnderground{apiKey: "1df429f462bc7ee1"},
-		forecastIo{apiKey: "12e03ff21975540f37c2b8cc79e3093b"},
+// parseCoord reads ?lat=&lon=. ok is false when neither is given.
+func parseCoord(q url.Values) (coord Coord, ok bool, err error) {
+	if !q.Has("lat") && !q.Has("lon") {
+		return Coord{}, false, nil
+	}
+	lat, errLat := strconv.ParseFloat(q.Get("lat"), 64)
+	lon, errLon := strconv.ParseFloat(q.Get("lon"), 64)
+	if errLat != nil || errLon != nil || math.Abs(lat) > 90 || math.Abs(lon) > 180 {
+		return Coord{}, false, fmt.Errorf("lat and lon must be numbers within ±90 and ±180")
 	}
+	return Coord{Lon: lon, Lat: lat}, true, nil
+}
 
-	begin := time.Now()
-	city := strings.SplitN(r.URL.Path, "/", 3)[2]
+// parseLocation reads the location of a /weather request: ?lat=&lon=
+// when given, otherwise the city.
+func parseLocation(r *http.Request) (Location, error) {
+	q := r.URL.Query()
+	if coord, ok, err := parseCoord(q); ok || err != nil {
+		return Location{Coord: &coord}, err
+	}
+	if q.Get("city") == "" && r.PathValue("city") == "" {
+		return Location{}, fmt.Errorf("want %s/weather?city=.. or %s/weather?lat=..&lon=..", apiVersion, apiVersion)
+	}
+	return requestLocation(r)
+}
+
+// parseCity splits "name,CC" into a Location. A non-empty country, from
+// ?country=, takes precedence over the one in the name.
+func parseCity(city, country string) (Location, error) {
+	name, cc, _ := strings.Cut(city, ",")
+	if country != "" {
+		cc = country
+	}
+	loc := Location{City: strings.TrimSpace(name), Country: strings.ToUpper(strings.TrimSpace(cc))}
+	if loc.City == "" {
+		return Location{}, fmt.Errorf("missing city name")
+	}
+	if loc.Country != "" && (len(loc.Country) != 2 || strings.Trim(loc.Country, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "") {
+		return Location{}, fmt.Errorf("country must be a two-letter ISO 3166-1 code, got %q", cc)
+	}
+	return loc, nil
+}
 
-	temp, err := mw.temperature(city)
+// maxCityLength bounds city names, in characters; the longest place
+// names in use are well below it.
+const maxCityLength = 100
+
+// validCity checks a city as given by a client, optionally followed by
+// ",CC": letters in any script, digits, spaces and the punctuation found
+// in place names. Anything else, such as slashes, query syntax or control
+// characters, has no business in a city name or an upstream URL.
+func validCity(city string) error {
+	if utf8.RuneCountInString(city) > maxCityLength {
+		return fmt.Errorf("city is longer than %d characters", maxCityLength)
+	}
+	if !utf8.ValidString(city) {
+		return fmt.Errorf("city is not valid UTF-8")
+	}
+	if strings.Count(city, ",") > 1 {
+		return fmt.Errorf("city may contain one comma, before the country code")
+	}
+	for _, c := range city {
+		if !unicode.IsLetter(c) && !unicode.IsMark(c) && !unicode.IsDigit(c) && !strings.ContainsRune(" -'’.,()", c) {
+			return fmt.Errorf("invalid character %q in city", c)
+		}
+	}
+	return nil
+}
+
+// fetchReport returns the report for loc from the cache, looking it up
+// and caching it on a miss. When the lookup fails it falls back to an
+// expired entry, marked Stale; key and stored identify the entry served.
+func fetchReport(ctx context.Context, mw weatherProvider, cache *reportCache, loc Location, units unitSystem, pol policy) (report weatherReport, key string, stored time.Time, err error) {
+	key = cacheKey(loc.String(), units, pol.aggregator)
+	_, s := startSpan(ctx, "cache.get", spanInternal)
+	report, stored, cached := cache.get(key)
+	s.set("cache.hit", cached)
+	s.finish(nil)
+	if cached {
+		report.Cached = true
+		report.Age = time.Since(stored).Round(time.Second).String()
+		return report, key, stored, nil
+	}
+
+	report, stored, err = refreshReport(ctx, mw, cache, loc, units, pol)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		report, stored, cached = cache.stale(key)
+		if !cached {
+			return weatherReport{}, key, time.Time{}, err
+		}
+		slog.WarnContext(ctx, "serving stale report", "city", loc.String(), "error", err)
+		report.Cached = true
+		report.Stale = true
+		report.Age = time.Since(stored).Round(time.Second).String()
 	}
+	return report, key, stored, nil
+}
 
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"city": city,
-		"temp": temp,
-		"took": time.Since(begin).String(),
-	})
+// refreshReport looks loc up from the providers, bypassing the cache,
+// and caches the new report.
+func refreshReport(ctx context.Context, mw weatherProvider, cache *reportCache, loc Location, units unitSystem, pol policy) (weatherReport, time.Time, error) {
+	c, err := lookup(ctx, mw, loc, pol)
+	if err != nil {
+		return weatherReport{}, time.Time{}, err
+	}
+
+	for _, s := range c.sources {
+		if s.Temp != nil {
+			*s.Temp = units.temperature(*s.Temp)
+		}
+	}
+	for i := range c.discarded {
+		c.discarded[i].Temp = units.temperature(c.discarded[i].Temp)
+	}
+
+	report := weatherReport{
+		City:       loc.City,
+		Country:    loc.Country,
+		Coord:      loc.Coord,
+		Temp:       units.temperature(c.temp),
+		Units:      units,
+		TempUnit:   units.temperatureUnit(),
+		Aggregator: pol.aggregator.name(),
+		Weights:    c.weights,
+		Failed:     c.failed,
+		Discarded:  c.discarded,
+		Sources:    c.sources,
+	}
+
+	// Report the country the name resolved to, so clients can tell
+	// when they got a different Springfield. Only providers that work
+	// from coordinates geocode the name; the others, and -synthetic,
+	// should not cost a geocoder call on every miss just for this.
+	if loc.Coord == nil {
+		if p, ok := geocodes.lookup(loc.query()); ok {
+			report.Country = p.Country
+		}
+	}
+
+	stored := time.Now()
+	cache.put(cacheKey(loc.String(), units, pol.aggregator), report, stored)
+	return report, stored, nil
+}
+
+func weather(mw weatherProvider, cache *reportCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		begin := time.Now()
+		loc, err := parseLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		units, err := parseUnitSystem(r.URL.Query().Get("units"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		format, err := parseFormat(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		pol := defaultPolicy
+		if agg := r.URL.Query().Get("agg"); agg != "" {
+			if pol.aggregator, err = parseAggregator(agg); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		} else if arm := experimentArmFor(r.Context()); arm != nil {
+			pol.aggregator = arm.aggregator
+		}
+
+		report, key, stored, err := fetchReport(r.Context(), mw, cache, loc, units, pol)
+		if err != nil {
+			http.Error(w, publicError(err), http.StatusInternalServerError)
+			return
+		}
+		recordAgreement(r.Context(), report)
+		if report.Stale {
+			report.Took = time.Since(begin).String()
+			w.Header().Set("Cache-Control", "no-cache")
+			writeJSON(w, r, report)
+			return
+		}
+		report.Took = time.Since(begin).String()
+
+		// The tag covers the query too: format, fields and precision all
+		// change the representation.
+		if cache.ttl > 0 {
+			if notModified(w, r, etag(key, stored.Format(time.RFC3339Nano), r.URL.RawQuery), cache.ttl-time.Since(stored)) {
+				return
+			}
+			w = uncacheableErrors{w}
+		}
+
+		if tmpl := r.URL.Query().Get("template"); tmpl != "" {
+			if !featureEnabled(r, "templates") {
+				http.Error(w, "templates are disabled", http.StatusBadRequest)
+				return
+			}
+			writeTemplate(w, tmpl, report)
+			return
+		}
+
+		if format == "geojson" {
+			if loc.Coord != nil {
+				writeGeoJSON(w, r, *loc.Coord, report)
+				return
+			}
+			coord, err := locateCoord(r.Context(), loc.query())
+			if err != nil {
+				http.Error(w, publicError(err), http.StatusInternalServerError)
+				return
+			}
+			writeGeoJSON(w, r, coord, report)
+			return
+		}
+
+		writeJSON(w, r, report)
+	}
 }
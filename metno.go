@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// metNo is the Norwegian Meteorological Institute's Locationforecast
+// 2.0 API. It is free and keyless but its terms of service require an
+// identifying User-Agent, coordinates of at most four decimals, and that
+// clients honour Expires and revalidate with If-Modified-Since rather
+// than refetch.
+type metNo struct{}
+
+func init() {
+	RegisterProvider("metNo", func(pc *providerConfig) (weatherProvider, error) {
+		return metNo{}, nil
+	})
+}
+
+// metNoResponse is a cached Locationforecast document with the headers
+// needed to reuse and revalidate it.
+type metNoResponse struct {
+	Body         json.RawMessage `json:"body"`
+	LastModified string          `json:"last_modified"`
+	Expires      time.Time       `json:"expires"`
+}
+
+// metNoRetention is how long a document is kept past its expiry for
+// revalidation.
+const metNoRetention = time.Hour
+
+func (w metNo) url(coord Coord) string {
+	return baseURL("metNo") + fmt.Sprintf("/weatherapi/locationforecast/2.0/compact?lat=%.4f&lon=%.4f", coord.Lat, coord.Lon)
+}
+
+// fetch returns the document at url, from the cache until it expires
+// and then revalidated with If-Modified-Since.
+func (w metNo) fetch(ctx context.Context, url string) ([]byte, error) {
+	key := "metno:" + url
+	var cached metNoResponse
+	if b, ok := geocodes.store.get(key); ok && json.Unmarshal(b, &cached) == nil {
+		if time.Now().Before(cached.Expires) {
+			return cached.Body, nil
+		}
+	} else {
+		cached = metNoResponse{}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	if id := requestID(ctx); id != "" {
+		req.Header.Set("X-Request-ID", id)
+	}
+	if cached.LastModified != "" {
+		req.Header.Set("If-Modified-Since", cached.LastModified)
+	}
+
+	resp, err := guarded(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNonAuthoritativeInfo:
+		// 203 marks a deprecated product version; the data is still good.
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		cached = metNoResponse{Body: body, LastModified: resp.Header.Get("Last-Modified")}
+	case http.StatusNotModified:
+		if cached.Body == nil {
+			return nil, fmt.Errorf("metNo: 304 without a cached document")
+		}
+	default:
+		return nil, fmt.Errorf("metNo: %s", resp.Status)
+	}
+
+	cached.Expires = time.Now()
+	if t, err := http.ParseTime(resp.Header.Get("Expires")); err == nil {
+		cached.Expires = t
+	}
+	if b, err := json.Marshal(cached); err == nil {
+		geocodes.store.set(key, b, time.Until(cached.Expires)+metNoRetention)
+	}
+	return cached.Body, nil
+}
+
+// now returns the instant details of the first timestep, which is the
+// current hour.
+func (w metNo) now(ctx context.Context, coord Coord) (Observation, error) {
+	body, err := w.fetch(ctx, w.url(coord))
+	if err != nil {
+		return Observation{}, err
+	}
+
+	var d struct {
+		Properties struct {
+			Timeseries []struct {
+				Data struct {
+					Instant struct {
+						Details struct {
+							Temp          *float64 `json:"air_temperature"`
+							Humidity      *float64 `json:"relative_humidity"`
+							WindSpeed     *float64 `json:"wind_speed"`
+							WindDirection *float64 `json:"wind_from_direction"`
+							Pressure      *float64 `json:"air_pressure_at_sea_level"`
+							CloudCover    *float64 `json:"cloud_area_fraction"`
+						} `json:"details"`
+					} `json:"instant"`
+				} `json:"data"`
+			} `json:"timeseries"`
+		} `json:"properties"`
+	}
+
+	if err := json.Unmarshal(body, &d); err != nil {
+		return Observation{}, err
+	}
+	if len(d.Properties.Timeseries) == 0 {
+		return Observation{}, fmt.Errorf("metNo: empty timeseries")
+	}
+
+	v := d.Properties.Timeseries[0].Data.Instant.Details
+	return Observation{
+		Temp:          v.Temp,
+		Humidity:      v.Humidity,
+		WindSpeed:     v.WindSpeed,
+		WindDirection: v.WindDirection,
+		Pressure:      v.Pressure,
+		CloudCover:    v.CloudCover,
+	}, nil
+}
+
+func (w metNo) temperature(ctx context.Context, loc Location) (float64, error) {
+	var coord Coord
+	if loc.Coord != nil {
+		coord = *loc.Coord
+	} else {
+		var err error
+		if coord, err = locateCoord(ctx, loc.query()); err != nil {
+			return 0, err
+		}
+	}
+
+	celsius, err := w.temperatureAt(ctx, coord)
+	if err != nil {
+		return 0, err
+	}
+
+	logReading(ctx, "metNo", loc, celsius)
+	return celsius, nil
+}
+
+func (w metNo) temperatureAt(ctx context.Context, coord Coord) (float64, error) {
+	o, err := w.now(ctx, coord)
+	if err != nil {
+		return 0, err
+	}
+	if o.Temp == nil {
+		return 0, fmt.Errorf("metNo: no temperature")
+	}
+	return *o.Temp, nil
+}
+
+func (w metNo) observe(ctx context.Context, city string) (Observation, error) {
+	coord, err := locateCoord(ctx, city)
+	if err != nil {
+		return Observation{}, err
+	}
+	return w.now(ctx, coord)
+}
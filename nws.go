@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+func init() {
+	RegisterProvider("nationalWeatherService", func(pc *providerConfig) (weatherProvider, error) {
+		return nationalWeatherService{}, nil
+	})
+}
+
+// nwsCountries are the country codes the National Weather Service
+// forecasts for: the United States and its territories.
+var nwsCountries = map[string]bool{"US": true, "PR": true, "VI": true, "GU": true, "AS": true, "MP": true}
+
+// gridpointTTL is how long the forecast URL of a point is reused. NWS
+// grids change rarely and the lookup costs a request per location.
+const gridpointTTL = 24 * time.Hour
+
+func (w nationalWeatherService) temperature(ctx context.Context, loc Location) (float64, error) {
+	coord, country := loc.Coord, loc.Country
+	if coord == nil {
+		p, err := locate(ctx, loc.query())
+		if err != nil {
+			return 0, err
+		}
+		coord = &p.Coord
+		if country == "" {
+			country = p.Country
+		}
+	}
+	// Spare the gridpoint lookup when the country is known.
+	if country != "" && !nwsCountries[country] {
+		return 0, errNotCovered
+	}
+
+	celsius, err := w.temperatureAt(ctx, *coord)
+	if err != nil {
+		return 0, err
+	}
+
+	logReading(ctx, "nationalWeatherService", loc, celsius)
+	return celsius, nil
+}
+
+// temperatureAt reads the current hour of the point's hourly forecast,
+// which the NWS keeps up to date from observations.
+func (w nationalWeatherService) temperatureAt(ctx context.Context, coord Coord) (float64, error) {
+	forecast, err := w.gridpoint(ctx, coord)
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := httpGet(ctx, forecast+"?units=si")
+	if err != nil {
+		return 0, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("nationalWeatherService: %s", resp.Status)
+	}
+
+	var d struct {
+		Properties struct {
+			Periods []struct {
+				Temperature     float64 `json:"temperature"`
+				TemperatureUnit string  `json:"temperatureUnit"`
+			} `json:"periods"`
+		} `json:"properties"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&d); err != nil {
+		return 0, err
+	}
+	if len(d.Properties.Periods) == 0 {
+		return 0, fmt.Errorf("nationalWeatherService: empty forecast")
+	}
+
+	p := d.Properties.Periods[0]
+	if p.TemperatureUnit == "F" {
+		return (p.Temperature - 32) * 5 / 9, nil
+	}
+	return p.Temperature, nil
+}
+
+// gridpoint resolves coord to the hourly forecast URL of its NWS grid
+// cell, the first of the two requests a forecast takes. Points outside
+// the NWS area are answered with 404 and remembered as errNotCovered.
+func (w nationalWeatherService) gridpoint(ctx context.Context, coord Coord) (string, error) {
+	key := fmt.Sprintf("nws:%.4f,%.4f", coord.Lat, coord.Lon)
+	if b, ok := geocodes.store.get(key); ok {
+		if len(b) == 0 {
+			return "", errNotCovered
+		}
+		return string(b), nil
+	}
+
+	resp, err := httpGet(ctx, baseURL("nationalWeatherService")+fmt.Sprintf("/points/%.4f,%.4f", coord.Lat, coord.Lon))
+	if err != nil {
+		return "", err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		geocodes.store.set(key, []byte{}, gridpointTTL)
+		return "", errNotCovered
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("nationalWeatherService: %s", resp.Status)
+	}
+
+	var d struct {
+		Properties struct {
+			ForecastHourly string `json:"forecastHourly"`
+		} `json:"properties"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&d); err != nil {
+		return "", err
+	}
+
+	// The forecast URL points at api.weather.gov; keep requests on the
+	// configured upstream so that sandbox environments stay offline.
+	u, err := url.Parse(d.Properties.ForecastHourly)
+	if err != nil || u.Path == "" {
+		return "", fmt.Errorf("nationalWeatherService: no forecast for %.4f,%.4f", coord.Lat, coord.Lon)
+	}
+	forecast := baseURL("nationalWeatherService") + u.Path
+
+	geocodes.store.set(key, []byte(forecast), gridpointTTL)
+	return forecast, nil
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// openMeteo is the Open-Meteo forecast API. It is free for
+// non-commercial use and needs no key, which makes it the provider that
+// works out of the box.
+type openMeteo struct{}
+
+func init() {
+	RegisterProvider("openMeteo", func(pc *providerConfig) (weatherProvider, error) {
+		return openMeteo{}, nil
+	})
+}
+
+func (w openMeteo) url(coord Coord, params string) string {
+	return baseURL("openMeteo") + "/v1/forecast?latitude=" + FloatToString(coord.Lat) + "&longitude=" + FloatToString(coord.Lon) + "&" + params
+}
+
+// wmoCondition maps a WMO weather interpretation code, as used by
+// Open-Meteo, onto the response vocabulary.
+func wmoCondition(code int) string {
+	switch {
+	case code == 0:
+		return "clear"
+	case code <= 2:
+		return "partly-cloudy"
+	case code == 3:
+		return "cloudy"
+	case code == 45 || code == 48:
+		return "fog"
+	case code == 56 || code == 57 || code == 66 || code == 67:
+		return "sleet"
+	case code >= 51 && code <= 67, code >= 80 && code <= 82:
+		return "rain"
+	case code >= 71 && code <= 77, code == 85 || code == 86:
+		return "snow"
+	case code >= 95:
+		return "thunderstorm"
+	}
+	return "unknown"
+}
+
+func (w openMeteo) temperature(ctx context.Context, loc Location) (float64, error) {
+	var coord Coord
+	if loc.Coord != nil {
+		coord = *loc.Coord
+	} else {
+		var err error
+		if coord, err = locateCoord(ctx, loc.query()); err != nil {
+			return 0, err
+		}
+	}
+
+	celsius, err := w.temperatureAt(ctx, coord)
+	if err != nil {
+		return 0, err
+	}
+
+	logReading(ctx, "openMeteo", loc, celsius)
+	return celsius, nil
+}
+
+func (w openMeteo) temperatureAt(ctx context.Context, coord Coord) (float64, error) {
+	o, err := w.current(ctx, coord)
+	if err != nil {
+		return 0, err
+	}
+	if o.Temp == nil {
+		return 0, fmt.Errorf("openMeteo: no temperature")
+	}
+	return *o.Temp, nil
+}
+
+func (w openMeteo) observe(ctx context.Context, city string) (Observation, error) {
+	coord, err := locateCoord(ctx, city)
+	if err != nil {
+		return Observation{}, err
+	}
+	return w.current(ctx, coord)
+}
+
+func (w openMeteo) current(ctx context.Context, coord Coord) (Observation, error) {
+	resp, err := httpGet(ctx, w.url(coord, "wind_speed_unit=ms&current=temperature_2m,relative_humidity_2m,wind_speed_10m,wind_direction_10m,pressure_msl,cloud_cover,visibility,uv_index"))
+	if err != nil {
+		return Observation{}, err
+	}
+
+	defer resp.Body.Close()
+
+	var d struct {
+		Current struct {
+			Temp          *float64 `json:"temperature_2m"`
+			Humidity      *float64 `json:"relative_humidity_2m"`
+			WindSpeed     *float64 `json:"wind_speed_10m"`
+			WindDirection *float64 `json:"wind_direction_10m"`
+			Pressure      *float64 `json:"pressure_msl"`
+			CloudCover    *float64 `json:"cloud_cover"`
+			Visibility    *float64 `json:"visibility"`
+			UVIndex       *float64 `json:"uv_index"`
+		} `json:"current"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&d); err != nil {
+		return Observation{}, err
+	}
+
+	c := d.Current
+	o := Observation{
+		Temp:          c.Temp,
+		Humidity:      c.Humidity,
+		WindSpeed:     c.WindSpeed,
+		WindDirection: c.WindDirection,
+		Pressure:      c.Pressure,
+		CloudCover:    c.CloudCover,
+		UVIndex:       c.UVIndex,
+	}
+	if c.Visibility != nil {
+		o.Visibility = ptr(*c.Visibility / 1000)
+	}
+	return o, nil
+}
+
+// openMeteoDaily is the shape of Open-Meteo's daily block, shared by the forecast
+// and archive APIs.
+type openMeteoDaily struct {
+	Daily struct {
+		Time []string   `json:"time"`
+		High []*float64 `json:"temperature_2m_max"`
+		Low  []*float64 `json:"temperature_2m_min"`
+		Code []*int     `json:"weather_code"`
+	} `json:"daily"`
+}
+
+func (d openMeteoDaily) days() ([]dailyForecast, error) {
+	v := d.Daily
+	if len(v.High) != len(v.Time) || len(v.Low) != len(v.Time) || len(v.Code) != len(v.Time) {
+		return nil, fmt.Errorf("openMeteo: daily arrays differ in length")
+	}
+	out := make([]dailyForecast, len(v.Time))
+	for i := range v.Time {
+		// Days the archive has not caught up with come back as nulls.
+		if v.High[i] == nil || v.Low[i] == nil {
+			return nil, fmt.Errorf("openMeteo: no data for %s", v.Time[i])
+		}
+		out[i] = dailyForecast{date: v.Time[i], high: *v.High[i], low: *v.Low[i], condition: "unknown"}
+		if v.Code[i] != nil {
+			out[i].condition = wmoCondition(*v.Code[i])
+		}
+	}
+	return out, nil
+}
+
+func (w openMeteo) forecast(ctx context.Context, city string, days int) ([]dailyForecast, error) {
+	coord, err := locateCoord(ctx, city)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := httpGet(ctx, w.url(coord, "timezone=auto&daily=temperature_2m_max,temperature_2m_min,weather_code&forecast_days="+strconv.Itoa(days)))
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	var d openMeteoDaily
+	if err := json.NewDecoder(resp.Body).Decode(&d); err != nil {
+		return nil, err
+	}
+	return d.days()
+}
+
+func (w openMeteo) hourly(ctx context.Context, city string, hours int) ([]hourlyForecast, error) {
+	coord, err := locateCoord(ctx, city)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := httpGet(ctx, w.url(coord, "timeformat=unixtime&hourly=temperature_2m,precipitation_probability&forecast_hours="+strconv.Itoa(hours)))
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	var d struct {
+		Hourly struct {
+			Time          []int64    `json:"time"`
+			Temp          []float64  `json:"temperature_2m"`
+			Precipitation []*float64 `json:"precipitation_probability"` // percent
+		} `json:"hourly"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&d); err != nil {
+		return nil, err
+	}
+
+	h := d.Hourly
+	if len(h.Temp) != len(h.Time) || len(h.Precipitation) != len(h.Time) {
+		return nil, fmt.Errorf("openMeteo: hourly arrays differ in length")
+	}
+	out := make([]hourlyForecast, len(h.Time))
+	for i := range h.Time {
+		out[i] = hourlyForecast{time: time.Unix(h.Time[i], 0), temp: h.Temp[i]}
+		if p := h.Precipitation[i]; p != nil {
+			out[i].precipitation = *p / 100
+		}
+	}
+	return out, nil
+}
+
+// history reads the ERA5-based archive API, which lags real time by a
+// few days.
+func (w openMeteo) history(ctx context.Context, city string, date time.Time) (dailyForecast, error) {
+	coord, err := locateCoord(ctx, city)
+	if err != nil {
+		return dailyForecast{}, err
+	}
+
+	day := date.Format("2006-01-02")
+	resp, err := httpGet(ctx, baseURL("openMeteoArchive")+"/v1/archive?latitude="+FloatToString(coord.Lat)+"&longitude="+FloatToString(coord.Lon)+
+		"&timezone=auto&daily=temperature_2m_max,temperature_2m_min,weather_code&start_date="+day+"&end_date="+day)
+	if err != nil {
+		return dailyForecast{}, err
+	}
+
+	defer resp.Body.Close()
+
+	var d openMeteoDaily
+	if err := json.NewDecoder(resp.Body).Decode(&d); err != nil {
+		return dailyForecast{}, err
+	}
+	days, err := d.days()
+	if err != nil {
+		return dailyForecast{}, err
+	}
+	if len(days) == 0 {
+		return dailyForecast{}, fmt.Errorf("openMeteo: no archive data for %s", day)
+	}
+	return days[0], nil
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// pirateWeather is Pirate Weather, which serves the Dark Sky API that
+// forecast.io used to: same paths, same documents, US units by default.
+type pirateWeather struct {
+	apiKey string
+}
+
+func init() {
+	RegisterProvider("pirateWeather", func(pc *providerConfig) (weatherProvider, error) {
+		if pc.apiKey == "" {
+			return nil, errNoAPIKey
+		}
+		return pirateWeather{apiKey: pc.apiKey}, nil
+	})
+}
+
+// forecastIo was the Dark Sky API at api.forecast.io, which shut down in
+// March 2023 and has answered with errors since. Configs naming it get
+// pirateWeather instead.
+//
+// Deprecated: use pirateWeather.
+type forecastIo = pirateWeather
+
+func (w pirateWeather) url(coord Coord) string {
+	return baseURL("pirateWeather") + "/forecast/" + w.apiKey + "/" + FloatToString(coord.Lat) + "," + FloatToString(coord.Lon)
+}
+
+func (w pirateWeather) temperature(ctx context.Context, loc Location) (float64, error) {
+	coord := loc.Coord
+	if coord == nil {
+		c, err := locateCoord(ctx, loc.query())
+		if err != nil {
+			return 0, err
+		}
+		coord = &c
+	}
+
+	celsius, err := w.temperatureAt(ctx, *coord)
+	if err != nil {
+		return 0, err
+	}
+
+	logReading(ctx, "pirateWeather", loc, celsius)
+	return celsius, nil
+}
+
+func (w pirateWeather) temperatureAt(ctx context.Context, coord Coord) (float64, error) {
+	resp, err := httpGet(ctx, w.url(coord))
+	if err != nil {
+		return 0, err
+	}
+
+	defer resp.Body.Close()
+
+	var d struct {
+		Currently struct {
+			Fahrenheit float64 `json:"temperature"`
+		} `json:"currently"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&d); err != nil {
+		return 0, err
+	}
+
+	return FahrenheitToCelsius(d.Currently.Fahrenheit), nil
+}
+
+func (w pirateWeather) observe(ctx context.Context, city string) (Observation, error) {
+	coord, err := locateCoord(ctx, city)
+	if err != nil {
+		return Observation{}, err
+	}
+
+	resp, err := httpGet(ctx, w.url(coord)+"?exclude=minutely,hourly,daily,alerts,flags")
+	if err != nil {
+		return Observation{}, err
+	}
+
+	defer resp.Body.Close()
+
+	var d struct {
+		Currently struct {
+			Fahrenheit    *float64 `json:"temperature"`
+			Humidity      *float64 `json:"humidity"`
+			WindSpeed     *float64 `json:"windSpeed"`
+			WindDirection *float64 `json:"windBearing"`
+			Pressure      *float64 `json:"pressure"`
+			CloudCover    *float64 `json:"cloudCover"`
+			Visibility    *float64 `json:"visibility"`
+			UVIndex       *float64 `json:"uvIndex"`
+		} `json:"currently"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&d); err != nil {
+		return Observation{}, err
+	}
+
+	// Dark Sky reported fractions and US units by default.
+	c := d.Currently
+	o := Observation{WindDirection: c.WindDirection, Pressure: c.Pressure, UVIndex: c.UVIndex}
+	if c.Fahrenheit != nil {
+		o.Temp = ptr(FahrenheitToCelsius(*c.Fahrenheit))
+	}
+	if c.Humidity != nil {
+		o.Humidity = ptr(*c.Humidity * 100)
+	}
+	if c.WindSpeed != nil {
+		o.WindSpeed = ptr(*c.WindSpeed * 0.44704)
+	}
+	if c.CloudCover != nil {
+		o.CloudCover = ptr(*c.CloudCover * 100)
+	}
+	if c.Visibility != nil {
+		o.Visibility = ptr(*c.Visibility * 1.609344)
+	}
+	return o, nil
+}
+
+func (w pirateWeather) alerts(ctx context.Context, coord Coord) ([]weatherAlert, error) {
+	resp, err := httpGet(ctx, w.url(coord)+"?exclude=currently,minutely,hourly,daily,flags")
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	var d struct {
+		Alerts []struct {
+			Title       string `json:"title"`
+			Severity    string `json:"severity"`
+			Time        int64  `json:"time"`
+			Expires     int64  `json:"expires"`
+			Description string `json:"description"`
+		} `json:"alerts"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&d); err != nil {
+		return nil, err
+	}
+
+	severity := map[string]string{"advisory": "minor", "watch": "moderate", "warning": "severe"}
+	var out []weatherAlert
+	for _, a := range d.Alerts {
+		s, ok := severity[a.Severity]
+		if !ok {
+			s = "unknown"
+		}
+		out = append(out, weatherAlert{
+			Source:      "pirateWeather",
+			Type:        a.Title,
+			Severity:    s,
+			Onset:       unixTime(a.Time),
+			Expires:     unixTime(a.Expires),
+			Description: a.Description,
+		})
+	}
+	return out, nil
+}
+
+func (w pirateWeather) forecast(ctx context.Context, city string, days int) ([]dailyForecast, error) {
+	coord, err := locateCoord(ctx, city)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := httpGet(ctx, w.url(coord)+"?exclude=currently,minutely,hourly,alerts,flags")
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	var d struct {
+		Offset float64 `json:"offset"`
+		Daily  struct {
+			Data []struct {
+				Time int64   `json:"time"`
+				High float64 `json:"temperatureMax"`
+				Low  float64 `json:"temperatureMin"`
+				Icon string  `json:"icon"`
+			} `json:"data"`
+		} `json:"daily"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&d); err != nil {
+		return nil, err
+	}
+
+	// Daily times are local midnights; the offset recovers the local date.
+	zone := time.FixedZone("", int(d.Offset*3600))
+	var out []dailyForecast
+	for _, day := range d.Daily.Data {
+		if len(out) == days {
+			break
+		}
+		out = append(out, dailyForecast{
+			date:      time.Unix(day.Time, 0).In(zone).Format("2006-01-02"),
+			high:      FahrenheitToCelsius(day.High),
+			low:       FahrenheitToCelsius(day.Low),
+			condition: normalizeCondition(day.Icon),
+		})
+	}
+	return out, nil
+}
+
+func (w pirateWeather) hourly(ctx context.Context, city string, hours int) ([]hourlyForecast, error) {
+	coord, err := locateCoord(ctx, city)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := httpGet(ctx, w.url(coord)+"?exclude=currently,minutely,daily,alerts,flags")
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	var d struct {
+		Hourly struct {
+			Data []struct {
+				Time          int64   `json:"time"`
+				Fahrenheit    float64 `json:"temperature"`
+				Precipitation float64 `json:"precipProbability"`
+			} `json:"data"`
+		} `json:"hourly"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&d); err != nil {
+		return nil, err
+	}
+
+	var out []hourlyForecast
+	for _, h := range d.Hourly.Data {
+		if len(out) == hours {
+			break
+		}
+		out = append(out, hourlyForecast{
+			time:          time.Unix(h.Time, 0),
+			temp:          FahrenheitToCelsius(h.Fahrenheit),
+			precipitation: h.Precipitation,
+		})
+	}
+	return out, nil
+}
+
+func (w pirateWeather) history(ctx context.Context, city string, date time.Time) (dailyForecast, error) {
+	coord, err := locateCoord(ctx, city)
+	if err != nil {
+		return dailyForecast{}, err
+	}
+
+	// A Time Machine request for noon UTC lands on the requested day
+	// almost everywhere. Pirate Weather serves these from a separate host.
+	resp, err := httpGet(ctx, baseURL("pirateWeatherTimeMachine")+"/forecast/"+w.apiKey+"/"+FloatToString(coord.Lat)+","+FloatToString(coord.Lon)+
+		","+strconv.FormatInt(date.Add(12*time.Hour).Unix(), 10)+"?exclude=currently,minutely,hourly,alerts,flags")
+	if err != nil {
+		return dailyForecast{}, err
+	}
+
+	defer resp.Body.Close()
+
+	var d struct {
+		Daily struct {
+			Data []struct {
+				High float64 `json:"temperatureMax"`
+				Low  float64 `json:"temperatureMin"`
+				Icon string  `json:"icon"`
+			} `json:"data"`
+		} `json:"daily"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&d); err != nil {
+		return dailyForecast{}, err
+	}
+	if len(d.Daily.Data) == 0 {
+		return dailyForecast{}, fmt.Errorf("pirateWeather: no data for %s", date.Format("2006-01-02"))
+	}
+
+	day := d.Daily.Data[0]
+	return dailyForecast{
+		date:      date.Format("2006-01-02"),
+		high:      FahrenheitToCelsius(day.High),
+		low:       FahrenheitToCelsius(day.Low),
+		condition: normalizeCondition(day.Icon),
+	}, nil
+}
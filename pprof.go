@@ -0,0 +1,28 @@
+package main
+
+import (
+	"expvar"
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+	"time"
+)
+
+// servePprof serves the net/http/pprof profiles and the expvar counters
+// on addr, apart from the API so that they are never reachable through
+// the public port. Bind it to localhost or an internal interface.
+func servePprof(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	srv := &http.Server{Addr: addr, Handler: mux, ReadTimeout: 10 * time.Second}
+	slog.Info("serving pprof", "addr", addr)
+	if err := srv.ListenAndServe(); err != nil {
+		slog.Error("pprof", "error", err)
+	}
+}
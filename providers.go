@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ProviderFactory builds a provider from its [providers.<name>] config
+// section. Providers that cannot run without an API key return
+// errNoAPIKey when pc has none.
+type ProviderFactory func(pc *providerConfig) (weatherProvider, error)
+
+var errNoAPIKey = errors.New("no API key")
+
+// providerFactories holds every registered provider by name.
+var providerFactories = map[string]ProviderFactory{}
+
+// RegisterProvider makes a provider available to the config under name.
+// Providers register themselves from an init function in their own file:
+//
+//	func init() {
+//		RegisterProvider("openMeteo", func(pc *providerConfig) (weatherProvider, error) {
+//			return openMeteo{}, nil
+//		})
+//	}
+//
+// Registering a name twice panics.
+func RegisterProvider(name string, factory ProviderFactory) {
+	if _, dup := providerFactories[name]; dup {
+		panic(fmt.Sprintf("RegisterProvider: %s registered twice", name))
+	}
+	providerFactories[name] = factory
+}
+
+// providerNames lists every registered provider in the order they are
+// aggregated, which is by name.
+func providerNames() []string {
+	var names []string
+	for name := range providerFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// providerKeyEnv names the environment variable holding a provider's
+// API key where it is not <NAME>_API_KEY.
+var providerKeyEnv = map[string]string{
+	"weatherUnderground": "WUNDERGROUND_API_KEY",
+}
+
+func keyEnv(name string) string {
+	if env, ok := providerKeyEnv[name]; ok {
+		return env
+	}
+	return strings.ToUpper(name) + "_API_KEY"
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxRateBuckets bounds the number of clients tracked; beyond it, buckets
+// that have refilled completely are dropped, since a fresh bucket is the
+// same thing.
+const maxRateBuckets = 10000
+
+// rateLimiter gives every client a token bucket that holds up to burst
+// requests and refills at rate per second. Requests finding it empty get
+// 429 with a Retry-After telling when the next token is due. Every
+// limited response reports the bucket in X-RateLimit-Limit, -Remaining
+// and -Reset (a Unix time), and in the IETF draft's RateLimit-Limit,
+// -Remaining and -Reset (in seconds); the reset is when the bucket is
+// full again. Clients are
+// told apart by their API key if it is a configured one, else by their
+// address; configured clients may have their own rate and burst. Health
+// checks are not limited.
+type rateLimiter struct {
+	rate    float64 // 0 leaves clients without their own limit unlimited
+	burst   float64
+	clients map[string]*apiClient // by key
+	next    http.Handler
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens      float64
+	last        time.Time
+	rate, burst float64
+}
+
+func newRateLimiter(rate float64, burst int, clients map[string]*apiClient, next http.Handler) *rateLimiter {
+	l := &rateLimiter{rate: rate, burst: float64(burst), clients: map[string]*apiClient{}, next: next, buckets: map[string]*tokenBucket{}}
+	for _, c := range clients {
+		l.clients[c.key] = c
+	}
+	return l
+}
+
+// clientKey names the bucket of a request: its API key when that belongs
+// to a declared client, otherwise its address. Unknown keys are ignored,
+// or a client could get a fresh bucket by sending a new key each time.
+func (l *rateLimiter) clientKey(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); l.clients[key] != nil {
+		return "key:" + key
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// take spends a token of key's bucket, or reports how long until one is
+// available as retry. It also returns the tokens left and how long until
+// the bucket is full.
+func (l *rateLimiter) take(key string, rate, burst float64) (ok bool, remaining int, retry, reset time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	b := l.buckets[key]
+	if b == nil {
+		if len(l.buckets) >= maxRateBuckets {
+			l.evict(now)
+		}
+		b = &tokenBucket{tokens: burst, last: now, rate: rate, burst: burst}
+		l.buckets[key] = b
+	}
+
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		ok = true
+	} else {
+		retry = time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	}
+	reset = time.Duration((burst - b.tokens) / rate * float64(time.Second))
+	return ok, int(b.tokens), retry, reset
+}
+
+func (l *rateLimiter) evict(now time.Time) {
+	for k, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*b.rate >= b.burst {
+			delete(l.buckets, k)
+		}
+	}
+}
+
+func (l *rateLimiter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if probePath(r.URL.Path) {
+		l.next.ServeHTTP(w, r)
+		return
+	}
+
+	rate, burst := l.rate, l.burst
+	if c := l.clients[r.Header.Get("X-API-Key")]; c != nil && c.rateLimit > 0 {
+		rate, burst = c.rateLimit, float64(c.rateBurst)
+	}
+	if rate == 0 {
+		l.next.ServeHTTP(w, r)
+		return
+	}
+
+	ok, remaining, retry, reset := l.take(l.clientKey(r), rate, burst)
+	h := w.Header()
+	limit, left, resetSecs := strconv.Itoa(int(burst)), strconv.Itoa(remaining), int64(math.Ceil(reset.Seconds()))
+	h.Set("X-RateLimit-Limit", limit)
+	h.Set("X-RateLimit-Remaining", left)
+	h.Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Unix()+resetSecs, 10))
+	h.Set("RateLimit-Limit", limit)
+	h.Set("RateLimit-Remaining", left)
+	h.Set("RateLimit-Reset", strconv.FormatInt(resetSecs, 10))
+	if !ok {
+		h.Set("Retry-After", strconv.Itoa(int(math.Ceil(retry.Seconds()))))
+		http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+		return
+	}
+	l.next.ServeHTTP(w, r)
+}
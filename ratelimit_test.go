@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRateLimitHeaders(t *testing.T) {
+	l := newRateLimiter(1, 2, nil, http.HandlerFunc(hello))
+
+	for i, want := range []struct {
+		status    int
+		remaining string
+	}{
+		{http.StatusOK, "1"},
+		{http.StatusOK, "0"},
+		{http.StatusTooManyRequests, "0"},
+	} {
+		rec := httptest.NewRecorder()
+		l.ServeHTTP(rec, httptest.NewRequest("GET", "/v1/weather/Oslo", nil))
+		h := rec.Header()
+		if rec.Code != want.status {
+			t.Fatalf("request %d: status %d, want %d", i, rec.Code, want.status)
+		}
+		for _, prefix := range []string{"X-RateLimit-", "RateLimit-"} {
+			if got := h.Get(prefix + "Limit"); got != "2" {
+				t.Errorf("request %d: %sLimit %q, want 2", i, prefix, got)
+			}
+			if got := h.Get(prefix + "Remaining"); got != want.remaining {
+				t.Errorf("request %d: %sRemaining %q, want %s", i, prefix, got, want.remaining)
+			}
+		}
+		if reset, err := strconv.Atoi(h.Get("RateLimit-Reset")); err != nil || reset < 1 || reset > 2 {
+			t.Errorf("request %d: RateLimit-Reset %q, want 1 or 2 seconds", i, h.Get("RateLimit-Reset"))
+		}
+		if reset, err := strconv.ParseInt(h.Get("X-RateLimit-Reset"), 10, 64); err != nil || reset < time.Now().Unix() {
+			t.Errorf("request %d: X-RateLimit-Reset %q, want a time to come", i, h.Get("X-RateLimit-Reset"))
+		}
+		if got := h.Get("Retry-After") != ""; got != (want.status == http.StatusTooManyRequests) {
+			t.Errorf("request %d: Retry-After %q", i, h.Get("Retry-After"))
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	l.ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+	if rec.Header().Get("RateLimit-Limit") != "" {
+		t.Errorf("health check got rate-limit headers")
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+)
+
+// recoverer turns a panicking handler into a logged stack trace and a 500
+// JSON error, instead of a dropped connection. If the response had already
+// begun, the client gets whatever was written.
+type recoverer struct {
+	next http.Handler
+}
+
+func (h recoverer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rec := &statusRecorder{ResponseWriter: w}
+	defer func() {
+		v := recover()
+		if v == nil {
+			return
+		}
+		if err, ok := v.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+			panic(v)
+		}
+		slog.ErrorContext(r.Context(), "panic", "method", r.Method, "path", r.URL.Path, "error", v, "stack", string(debug.Stack()))
+		if rec.status != 0 {
+			return
+		}
+		w.Header().Del("Content-Encoding")
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error":      "internal server error",
+			"request_id": requestID(r.Context()),
+		})
+	}()
+	h.next.ServeHTTP(rec, r)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// refreshUpdate is one refresh of a watched report, as published to
+// subscribers.
+type refreshUpdate struct {
+	report weatherReport
+	stored time.Time
+}
+
+// refresher keeps the reports that clients subscribe to up to date in
+// the background: each watched report is looked up again every interval,
+// stored in the report cache and published to its subscribers. A report
+// is watched for as long as it has subscribers, and watches are shared,
+// keyed like the cache.
+//
+// In a cluster only the leader looks reports up again, for the watches of
+// every instance; the others take each refresh from the shared cache, and
+// look reports up themselves only while no leader has refreshed them for
+// three intervals.
+type refresher struct {
+	mw       weatherProvider
+	cache    *reportCache
+	interval time.Duration
+	cluster  *cluster
+
+	mu      sync.Mutex
+	watches map[string]*watch
+}
+
+type watch struct {
+	subs map[chan refreshUpdate]struct{}
+	last *refreshUpdate
+	stop chan struct{}
+}
+
+func newRefresher(mw weatherProvider, cache *reportCache, interval time.Duration, cl *cluster) *refresher {
+	return &refresher{mw: mw, cache: cache, interval: interval, cluster: cl, watches: map[string]*watch{}}
+}
+
+// subscribe returns a channel of updates to the report for loc, starting
+// with the latest one when the report is already watched. A subscriber
+// that falls behind only misses intermediate updates: the channel always
+// ends up holding the newest. cancel closes the channel.
+func (rf *refresher) subscribe(loc Location, units unitSystem, pol policy) (updates <-chan refreshUpdate, cancel func()) {
+	key := cacheKey(loc.String(), units, pol.aggregator)
+	ch := make(chan refreshUpdate, 1)
+
+	rf.mu.Lock()
+	w := rf.watches[key]
+	if w == nil {
+		w = &watch{subs: map[chan refreshUpdate]struct{}{}, stop: make(chan struct{})}
+		rf.watches[key] = w
+		go rf.run(key, w, loc, units, pol)
+	}
+	w.subs[ch] = struct{}{}
+	if w.last != nil {
+		ch <- *w.last
+	}
+	rf.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			rf.mu.Lock()
+			defer rf.mu.Unlock()
+			delete(w.subs, ch)
+			close(ch)
+			if len(w.subs) == 0 {
+				close(w.stop)
+				delete(rf.watches, key)
+			}
+		})
+	}
+}
+
+// run refreshes one watched report until its last subscriber leaves.
+// The first pass takes the report from the cache when it is fresh.
+func (rf *refresher) run(key string, w *watch, loc Location, units unitSystem, pol policy) {
+	ticker := time.NewTicker(rf.interval)
+	defer ticker.Stop()
+
+	first := true
+	var last time.Time
+	for {
+		if rf.cluster != nil {
+			rf.cluster.watch(loc, units, pol, 3*rf.interval)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), rf.interval)
+		var report weatherReport
+		var stored time.Time
+		var err error
+		switch {
+		case first:
+			report, _, stored, err = fetchReport(ctx, rf.mw, rf.cache, loc, units, pol)
+		case rf.cluster != nil && time.Since(last) < 3*rf.interval:
+			report, stored, _ = rf.cache.load(key)
+		default:
+			report, stored, err = refreshReport(ctx, rf.mw, rf.cache, loc, units, pol)
+		}
+		cancel()
+
+		if err != nil {
+			slog.Warn("refresh failed", "city", loc.String(), "error", err)
+		} else if stored.After(last) {
+			last = stored
+			rf.publish(w, report, stored)
+		}
+		first = false
+
+		select {
+		case <-ticker.C:
+		case <-w.stop:
+			return
+		case <-shuttingDown:
+			return
+		}
+	}
+}
+
+// poll refreshes the reports watched anywhere in the cluster into the
+// shared cache every interval, while this instance leads.
+func (rf *refresher) poll() {
+	ticker := time.NewTicker(rf.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-shuttingDown:
+			return
+		}
+		if !rf.cluster.leader() {
+			continue
+		}
+		specs, err := rf.cluster.watched()
+		if err != nil {
+			slog.Warn("redis", "op", "watched reports", "error", err)
+			continue
+		}
+		for _, s := range specs {
+			loc, units, pol, err := s.target()
+			if err != nil {
+				continue
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), rf.interval)
+			if _, _, err := refreshReport(ctx, rf.mw, rf.cache, loc, units, pol); err != nil {
+				slog.Warn("refresh failed", "city", loc.String(), "error", err)
+			}
+			cancel()
+		}
+	}
+}
+
+func (rf *refresher) publish(w *watch, report weatherReport, stored time.Time) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	u := refreshUpdate{report: report, stored: stored}
+	w.last = &u
+	for ch := range w.subs {
+		select {
+		case <-ch: // drop the update the subscriber has not taken yet
+		default:
+		}
+		ch <- u
+	}
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// maxTemplateLen bounds user-supplied templates; they are meant for
+// one-line formats.
+const maxTemplateLen = 1024
+
+// precision holds the number of decimal places to keep per response
+// field, as requested with ?precision=2 or ?precision=1,temp:0,Lat:4.
+// A bare number applies to every field without an explicit entry.
+type precision struct {
+	all    int // -1 leaves values untouched
+	fields map[string]int
+}
+
+func parsePrecision(s string) (precision, error) {
+	p := precision{all: -1, fields: map[string]int{}}
+	if s == "" {
+		return p, nil
+	}
+
+	for _, part := range strings.Split(s, ",") {
+		name, digits := "", part
+		if i := strings.Index(part, ":"); i >= 0 {
+			name, digits = part[:i], part[i+1:]
+		}
+
+		n, err := strconv.Atoi(digits)
+		if err != nil || n < 0 || n > 15 {
+			return precision{}, fmt.Errorf("invalid precision %q", part)
+		}
+
+		if name == "" {
+			p.all = n
+		} else {
+			p.fields[name] = n
+		}
+	}
+
+	return p, nil
+}
+
+func (p precision) round(field string, v float64) float64 {
+	n, ok := p.fields[field]
+	if !ok {
+		n = p.all
+	}
+	if n < 0 {
+		return v
+	}
+	scale := math.Pow(10, float64(n))
+	return math.Round(v*scale) / scale
+}
+
+// apply rounds every number in a decoded JSON document in place, using
+// the name of the field holding the number.
+func (p precision) apply(doc interface{}, field string) interface{} {
+	switch v := doc.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = p.apply(e, k)
+		}
+	case []interface{}:
+		for i, e := range v {
+			v[i] = p.apply(e, field)
+		}
+	case float64:
+		return p.round(field, v)
+	}
+	return doc
+}
+
+// writeJSON is the serializer shared by all data endpoints. It writes v
+// after applying the response shaping options from the query string.
+func writeJSON(w http.ResponseWriter, r *http.Request, v interface{}) {
+	doc, status, err := shapeJSON(r, v)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(doc)
+}
+
+// writeGeoJSON writes v as the properties of a GeoJSON Feature located at
+// coord. The shaping options apply to the properties.
+func writeGeoJSON(w http.ResponseWriter, r *http.Request, coord Coord, v interface{}) {
+	props, status, err := shapeJSON(r, v)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/geo+json")
+	json.NewEncoder(w).Encode(feature(&coord, props))
+}
+
+// geoFeature is one Feature of a FeatureCollection: props located at
+// coord, or without a geometry when coord is nil.
+type geoFeature struct {
+	coord *Coord
+	props interface{}
+}
+
+// writeFeatureCollection writes features as a GeoJSON FeatureCollection.
+// The shaping options apply to the properties of each feature.
+func writeFeatureCollection(w http.ResponseWriter, r *http.Request, features []geoFeature) {
+	out := make([]interface{}, len(features))
+	for i, f := range features {
+		props, status, err := shapeJSON(r, f.props)
+		if err != nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
+		out[i] = feature(f.coord, props)
+	}
+
+	w.Header().Set("Content-Type", "application/geo+json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"type":     "FeatureCollection",
+		"features": out,
+	})
+}
+
+func feature(coord *Coord, props interface{}) map[string]interface{} {
+	var geometry interface{}
+	if coord != nil {
+		geometry = map[string]interface{}{
+			"type":        "Point",
+			"coordinates": []float64{coord.Lon, coord.Lat},
+		}
+	}
+	return map[string]interface{}{
+		"type":       "Feature",
+		"geometry":   geometry,
+		"properties": props,
+	}
+}
+
+// parseFormat validates ?format=, which selects between the plain JSON
+// document and a GeoJSON Feature.
+func parseFormat(r *http.Request) (string, error) {
+	switch f := r.URL.Query().Get("format"); f {
+	case "", "json":
+		return "json", nil
+	case "geojson":
+		return f, nil
+	default:
+		return "", fmt.Errorf("unknown format %q, want json or geojson", f)
+	}
+}
+
+// shapeJSON turns v into a generic JSON document and applies ?fields=,
+// ?exclude= and ?precision= to it. On error, the returned status tells
+// whether the client or the server is at fault.
+func shapeJSON(r *http.Request, v interface{}) (interface{}, int, error) {
+	q := r.URL.Query()
+
+	prec, err := parsePrecision(q.Get("precision"))
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	var doc interface{}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	doc, err = filterFields(doc, splitList(q.Get("fields")), splitList(q.Get("exclude")))
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	return prec.apply(doc, ""), http.StatusOK, nil
+}
+
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+// filterFields implements ?fields= and ?exclude= over a decoded JSON
+// document. Names are top-level keys or dotted paths into nested objects
+// (temp.Lat); a list document is filtered element by element. Naming a
+// field the document does not have is an error.
+func filterFields(doc interface{}, fields, exclude []string) (interface{}, error) {
+	if len(fields) == 0 && len(exclude) == 0 {
+		return doc, nil
+	}
+
+	if list, ok := doc.([]interface{}); ok {
+		for i, e := range list {
+			f, err := filterFields(e, fields, exclude)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = f
+		}
+		return list, nil
+	}
+
+	m, ok := doc.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("response has no fields to select")
+	}
+
+	if len(fields) > 0 {
+		picked := map[string]interface{}{}
+		for _, f := range fields {
+			if !pickField(picked, m, strings.Split(f, ".")) {
+				return nil, fmt.Errorf("unknown field %q", f)
+			}
+		}
+		m = picked
+	}
+
+	for _, f := range exclude {
+		if !dropField(m, strings.Split(f, ".")) {
+			return nil, fmt.Errorf("unknown field %q", f)
+		}
+	}
+
+	return m, nil
+}
+
+func pickField(dst, src map[string]interface{}, path []string) bool {
+	v, ok := src[path[0]]
+	if !ok {
+		return false
+	}
+	if len(path) == 1 {
+		dst[path[0]] = v
+		return true
+	}
+
+	sub, ok := v.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	next, ok := dst[path[0]].(map[string]interface{})
+	if !ok {
+		next = map[string]interface{}{}
+		dst[path[0]] = next
+	}
+	return pickField(next, sub, path[1:])
+}
+
+func dropField(m map[string]interface{}, path []string) bool {
+	v, ok := m[path[0]]
+	if !ok {
+		return false
+	}
+	if len(path) == 1 {
+		delete(m, path[0])
+		return true
+	}
+
+	sub, ok := v.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	return dropField(sub, path[1:])
+}
+
+// writeTemplate renders data with a client-supplied text/template and
+// writes it as plain text. The template is executed into a buffer first
+// so that errors such as unknown fields become a 400 instead of a
+// truncated body.
+func writeTemplate(w http.ResponseWriter, text string, data interface{}) {
+	if len(text) > maxTemplateLen {
+		http.Error(w, "template too long", http.StatusBadRequest)
+		return
+	}
+
+	t, err := template.New("response").Parse(text)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+// etag derives a strong entity tag from the parts that identify a
+// representation.
+func etag(parts ...string) string {
+	h := fnv.New64a()
+	for _, p := range parts {
+		h.Write([]byte(p))
+		h.Write([]byte{0})
+	}
+	return fmt.Sprintf("\"%016x\"", h.Sum64())
+}
+
+// uncacheableErrors withdraws the validators notModified set when the
+// response turns out to be an error, which must not be cached as the
+// representation they describe.
+type uncacheableErrors struct {
+	http.ResponseWriter
+}
+
+func (u uncacheableErrors) WriteHeader(status int) {
+	if status >= 400 {
+		u.Header().Del("ETag")
+		u.Header().Set("Cache-Control", "no-store")
+	}
+	u.ResponseWriter.WriteHeader(status)
+}
+
+func (u uncacheableErrors) Unwrap() http.ResponseWriter { return u.ResponseWriter }
+
+// notModified sets Cache-Control and ETag for a representation that stays
+// fresh for maxAge. If the request's If-None-Match already names tag it
+// answers 304 and returns true, and the caller must not write a body.
+// Otherwise the caller writes through uncacheableErrors, since rendering
+// can still fail.
+func notModified(w http.ResponseWriter, r *http.Request, tag string, maxAge time.Duration) bool {
+	if maxAge < 0 {
+		maxAge = 0
+	}
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(maxAge.Seconds())))
+	w.Header().Set("ETag", tag)
+
+	for _, t := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+		if t == tag || t == "*" {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
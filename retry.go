@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// retryPolicy retries upstream requests that fail transiently: network
+// errors and 5xx responses. The wait before retry n is backoff·2^(n-1),
+// randomized by ±jitter of itself. A retry that would not finish waiting
+// before the context's deadline is not attempted, so retries stay inside
+// the provider's timeout.
+type retryPolicy struct {
+	attempts int // including the first; 1 disables retries
+	backoff  time.Duration
+	jitter   float64
+}
+
+// upstreamRetry is the policy from retry_attempts, retry_backoff and
+// retry_jitter in the config.
+var upstreamRetry = retryPolicy{attempts: 2, backoff: 200 * time.Millisecond, jitter: 0.5}
+
+func (p retryPolicy) wait(attempt int) time.Duration {
+	d := p.backoff << (attempt - 1)
+	return d + time.Duration((rand.Float64()*2-1)*p.jitter*float64(d))
+}
+
+func (p retryPolicy) do(ctx context.Context, req *http.Request) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		_, s := startSpan(ctx, req.Method+" "+req.URL.Host, spanClient)
+		if s != nil {
+			req.Header.Set("traceparent", s.traceparent())
+			s.set("server.address", req.URL.Host)
+			s.set("http.attempt", attempt)
+		}
+		begin := time.Now()
+		resp, err := upstreamClient.Do(req)
+		logUpstream(ctx, req, attempt, resp, err, time.Since(begin))
+		spanErr := err
+		if err == nil {
+			s.set("http.status_code", resp.StatusCode)
+			if resp.StatusCode >= 500 {
+				spanErr = errors.New(resp.Status)
+			}
+		}
+		s.finish(spanErr)
+		transient := (err != nil && ctx.Err() == nil) || (err == nil && resp.StatusCode >= 500)
+		if !transient || attempt >= p.attempts {
+			return resp, err
+		}
+
+		wait := p.wait(attempt)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return resp, err
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, ctx.Err()
+		case <-t.C:
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// place is a named location found near some coordinates.
+type place struct {
+	City    string `json:"city"`
+	Country string `json:"country,omitempty"` // ISO 3166-1 alpha-2
+	Coord   Coord  `json:"coord"`
+}
+
+// reverseGeocoder turns coordinates into the nearest city.
+type reverseGeocoder interface {
+	reverse(ctx context.Context, coord Coord) (place, error)
+}
+
+// nominatim is OpenStreetMap's geocoder. It needs no key but asks for an
+// identifying User-Agent, which httpGet sends.
+type nominatim struct{}
+
+func (nominatim) reverse(ctx context.Context, coord Coord) (place, error) {
+	resp, err := httpGet(ctx, baseURL("nominatim")+"/reverse?format=jsonv2&zoom=10&lat="+FloatToString(coord.Lat)+"&lon="+FloatToString(coord.Lon))
+	if err != nil {
+		return place{}, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return place{}, fmt.Errorf("nominatim: %s", resp.Status)
+	}
+
+	var d struct {
+		Lat     string `json:"lat"`
+		Lon     string `json:"lon"`
+		Address struct {
+			City    string `json:"city"`
+			Town    string `json:"town"`
+			Village string `json:"village"`
+			Country string `json:"country_code"`
+		} `json:"address"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&d); err != nil {
+		return place{}, err
+	}
+
+	name := d.Address.City
+	if name == "" {
+		name = d.Address.Town
+	}
+	if name == "" {
+		name = d.Address.Village
+	}
+	if name == "" {
+		return place{}, fmt.Errorf("nominatim: no city near %s", Location{Coord: &coord})
+	}
+
+	p := place{City: name, Country: strings.ToUpper(d.Address.Country), Coord: coord}
+	fmt.Sscan(d.Lat, &p.Coord.Lat)
+	fmt.Sscan(d.Lon, &p.Coord.Lon)
+	return p, nil
+}
+
+// owmGeocoder is OpenWeatherMap's Geocoding API, which needs the
+// OpenWeatherMap key.
+type owmGeocoder struct {
+	apiKey string
+}
+
+func (g owmGeocoder) reverse(ctx context.Context, coord Coord) (place, error) {
+	resp, err := httpGet(ctx, baseURL("openWeatherMap")+"/geo/1.0/reverse?limit=1&lat="+FloatToString(coord.Lat)+"&lon="+FloatToString(coord.Lon)+"&appid="+g.apiKey)
+	if err != nil {
+		return place{}, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return place{}, fmt.Errorf("openWeatherMap: %s", resp.Status)
+	}
+
+	var d []struct {
+		Name    string  `json:"name"`
+		Country string  `json:"country"`
+		Lat     float64 `json:"lat"`
+		Lon     float64 `json:"lon"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&d); err != nil {
+		return place{}, err
+	}
+	if len(d) == 0 {
+		return place{}, fmt.Errorf("openWeatherMap: no city near %s", Location{Coord: &coord})
+	}
+
+	return place{City: d[0].Name, Country: d[0].Country, Coord: Coord{d[0].Lon, d[0].Lat}}, nil
+}
+
+type reverseReport struct {
+	place
+	Backend string `json:"backend"`
+	Took    string `json:"took"`
+}
+
+// reverse serves /reverse?lat=..&lon=..: the nearest city according to
+// the first backend that knows one. Answers are cached like geocodes, by
+// coordinates rounded to about a kilometre.
+func reverse(backends []reverseGeocoder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		begin := time.Now()
+
+		coord, ok, err := parseCoord(r.URL.Query())
+		if err == nil && !ok {
+			err = fmt.Errorf("lat and lon are required")
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var report reverseReport
+		key := fmt.Sprintf("reverse:%.2f,%.2f", coord.Lat, coord.Lon)
+		if b, ok := geocodes.store.get(key); ok && json.Unmarshal(b, &report) == nil {
+			report.Took = time.Since(begin).String()
+			writeJSON(w, r, report)
+			return
+		}
+
+		var errs []string
+		for _, b := range backends {
+			ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+			p, err := b.reverse(ctx, coord)
+			cancel()
+			if err != nil {
+				errs = append(errs, publicError(err))
+				continue
+			}
+
+			report = reverseReport{place: p, Backend: providerName(b)}
+			if cached, err := json.Marshal(report); err == nil {
+				geocodes.store.set(key, cached, geocodeTTL)
+			}
+			report.Took = time.Since(begin).String()
+			writeJSON(w, r, report)
+			return
+		}
+
+		http.Error(w, "no backend found a city: "+strings.Join(errs, "; "), http.StatusBadGateway)
+	}
+}
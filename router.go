@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// apiVersion prefixes every API route. The unversioned paths the API
+// started with remain as deprecated aliases.
+const apiVersion = "/v1"
+
+// route registers h for method and the path pattern under apiVersion,
+// and under the bare pattern as a deprecated alias. Patterns use the
+// http.ServeMux syntax, so handlers read path variables with PathValue.
+func route(mux *http.ServeMux, method, pattern string, h http.Handler) {
+	mux.Handle(method+" "+apiVersion+pattern, h)
+	mux.Handle(method+" "+pattern, deprecated{h})
+}
+
+// deprecated marks responses from an unversioned alias as such and
+// points to the versioned path (RFC 8594 and draft Deprecation header).
+type deprecated struct {
+	next http.Handler
+}
+
+func (d deprecated) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Deprecation", "true")
+	w.Header().Set("Link", "<"+apiVersion+r.URL.EscapedPath()+`>; rel="successor-version"`)
+	d.next.ServeHTTP(w, r)
+}
+
+// requestCity returns the city of a request: ?city= in the canonical
+// form, /{city} in the path-style one. Both arrive URL-decoded and must
+// pass validCity.
+func requestCity(r *http.Request) (string, error) {
+	query, path := r.URL.Query().Get("city"), r.PathValue("city")
+	city := query
+	switch {
+	case query != "" && path != "" && query != path:
+		return "", fmt.Errorf("city given both in the path and as ?city=")
+	case query == "" && path == "":
+		return "", fmt.Errorf("missing city: want ?city={city}")
+	case query == "":
+		city = path
+	}
+	if err := validCity(city); err != nil {
+		return "", err
+	}
+	return city, nil
+}
+
+// requestLocation is the city of a request as a Location, with the
+// country from "name,CC" or ?country=.
+func requestLocation(r *http.Request) (Location, error) {
+	city, err := requestCity(r)
+	if err != nil {
+		return Location{}, err
+	}
+	return parseCity(city, r.URL.Query().Get("country"))
+}
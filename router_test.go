@@ -0,0 +1,46 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestRoute checks the ServeMux patterns route registers, as the binary
+// is built: without go 1.22 semantics the mux matches them literally and
+// every route is a 404.
+func TestRoute(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/{$}", hello)
+	route(mux, "GET", "/weather/{city}", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.PathValue("city"))
+	}))
+
+	tests := []struct {
+		method, path string
+		status       int
+		body         string
+		deprecated   bool
+	}{
+		{"GET", "/", http.StatusOK, "", false},
+		{"GET", "/v1/weather/Oslo", http.StatusOK, "Oslo", false},
+		{"GET", "/weather/Oslo", http.StatusOK, "Oslo", true},
+		{"POST", "/v1/weather/Oslo", http.StatusMethodNotAllowed, "", false},
+		{"GET", "/v1/nowhere", http.StatusNotFound, "", false},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+		if rec.Code != tt.status {
+			t.Errorf("%s %s: status %d, want %d", tt.method, tt.path, rec.Code, tt.status)
+			continue
+		}
+		if tt.body != "" && rec.Body.String() != tt.body {
+			t.Errorf("%s %s: body %q, want %q", tt.method, tt.path, rec.Body, tt.body)
+		}
+		if got := rec.Header().Get("Deprecation") == "true"; got != tt.deprecated {
+			t.Errorf("%s %s: deprecated %v, want %v", tt.method, tt.path, got, tt.deprecated)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// selfCheck queries every provider in mw once for city, validating the
+// payload where the provider has a contract, and writes a pass/fail line
+// per provider to out. It reports whether every check passed.
+func selfCheck(out io.Writer, mw multiWeatherProvider, city string) bool {
+	fmt.Fprintf(out, "self-check against %q, environment %s\n", city, environment)
+
+	ok := true
+	for _, p := range mw {
+		begin := time.Now()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		var err error
+		if c, isChecked := p.(contractChecked); isChecked {
+			err = checkContract(ctx, c, city)
+		} else {
+			_, err = p.temperature(ctx, Location{City: city})
+		}
+		cancel()
+
+		if err != nil {
+			ok = false
+			fmt.Fprintf(out, "FAIL  %-20s %v%s\n", providerName(p), err, selfCheckHint(err))
+			continue
+		}
+		fmt.Fprintf(out, "PASS  %-20s %s\n", providerName(p), time.Since(begin).Round(time.Millisecond))
+	}
+
+	return ok
+}
+
+// selfCheckHint suggests what to look at for common failures.
+func selfCheckHint(err error) string {
+	var se statusError
+	var ne net.Error
+	switch {
+	case errors.As(err, &se) && (se.code == http.StatusUnauthorized || se.code == http.StatusForbidden):
+		return " (check the provider API key)"
+	case errors.As(err, &se) && se.code == http.StatusNotFound:
+		return " (check the base URL for this -env)"
+	case errors.As(err, &se) && se.code == http.StatusTooManyRequests:
+		return " (provider quota exhausted)"
+	case errors.As(err, &ne):
+		return " (check network access and DNS)"
+	}
+	return ""
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// partitionProviders splits mw into the providers named in the
+// comma-separated list and the rest. Naming a provider that is not in mw
+// is an error.
+func partitionProviders(mw multiWeatherProvider, list string) (named, rest multiWeatherProvider, err error) {
+	names := map[string]bool{}
+	for _, n := range strings.Split(list, ",") {
+		names[n] = true
+	}
+
+	for _, p := range mw {
+		if names[providerName(p)] {
+			named = append(named, p)
+			delete(names, providerName(p))
+		} else {
+			rest = append(rest, p)
+		}
+	}
+
+	for n := range names {
+		return nil, nil, fmt.Errorf("unknown provider %q", n)
+	}
+	return named, rest, nil
+}
+
+// shadowed queries shadow providers on every lookup alongside primary
+// and logs how far each one is from the primary's result, so a new
+// provider can be evaluated on real traffic. Shadow readings never reach
+// the response and shadows do not delay it.
+type shadowed struct {
+	primary weatherProvider
+	shadows multiWeatherProvider
+}
+
+// collect returns the primary readings. The shadows are queried
+// alongside and compared with the primary consensus once they answer.
+func (s shadowed) collect(ctx context.Context, loc Location) []sourceReading {
+	// Shadows may still be running after the response is written.
+	shadowCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Minute)
+
+	results := make(chan sourceReading, len(s.shadows))
+	for _, p := range s.shadows {
+		go func(p weatherProvider) {
+			ctx, cancel := providerContext(shadowCtx, p)
+			defer cancel()
+			begin := time.Now()
+			t, err := p.temperature(ctx, loc)
+			results <- sourceReading{providerName(p), t, time.Since(begin), err}
+		}(p)
+	}
+
+	var readings []sourceReading
+	if c, ok := s.primary.(collector); ok {
+		readings = c.collect(ctx, loc)
+	} else {
+		begin := time.Now()
+		t, err := s.primary.temperature(ctx, loc)
+		readings = []sourceReading{{providerName(s.primary), t, time.Since(begin), err}}
+	}
+	consensus, err := defaultPolicy.aggregate(readings)
+
+	go func() {
+		defer cancel()
+		for range s.shadows {
+			r := <-results
+			switch {
+			case r.err != nil:
+				slog.WarnContext(ctx, "shadow", "provider", r.provider, "city", loc.String(), "latency", r.latency, "error", r.err)
+			case err != nil:
+				slog.InfoContext(ctx, "shadow", "provider", r.provider, "city", loc.String(), "latency", r.latency, "temp", r.temp, "consensus_error", err)
+			default:
+				slog.InfoContext(ctx, "shadow", "provider", r.provider, "city", loc.String(), "latency", r.latency, "temp", r.temp, "consensus", consensus.temp, "delta", r.temp-consensus.temp)
+			}
+		}
+	}()
+
+	return readings
+}
+
+func (s shadowed) temperature(ctx context.Context, loc Location) (float64, error) {
+	c, err := defaultPolicy.aggregate(s.collect(ctx, loc))
+	return c.temp, err
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// statsWindow is how far back /stats/providers counts, in one-minute
+// buckets.
+const statsWindow = 15
+
+type statsBucket struct {
+	minute  int64 // Unix minute the bucket holds, to spot stale ones
+	calls   int
+	errors  int
+	latency time.Duration // total over calls
+}
+
+// providerStats is the rolling record of one provider's lookups.
+type providerStats struct {
+	buckets     [statsWindow]statsBucket
+	totalCalls  int64
+	totalErrors int64
+	lastSuccess time.Time
+	lastError   time.Time
+	lastErrMsg  string
+}
+
+var stats = struct {
+	sync.Mutex
+	m map[string]*providerStats
+}{m: map[string]*providerStats{}}
+
+// recordStats counts a lookup by the named provider. Locations it does
+// not cover are not lookups.
+func recordStats(name string, latency time.Duration, err error) {
+	if errors.Is(err, errNotCovered) {
+		return
+	}
+	now := time.Now()
+	minute := now.Unix() / 60
+
+	stats.Lock()
+	defer stats.Unlock()
+	s := stats.m[name]
+	if s == nil {
+		s = &providerStats{}
+		stats.m[name] = s
+	}
+	b := &s.buckets[minute%statsWindow]
+	if b.minute != minute {
+		*b = statsBucket{minute: minute}
+	}
+	b.calls++
+	b.latency += latency
+	s.totalCalls++
+	if err != nil {
+		b.errors++
+		s.totalErrors++
+		s.lastError, s.lastErrMsg = now, publicError(err)
+	} else {
+		s.lastSuccess = now
+	}
+}
+
+// providerStatsReport is one provider's entry in /stats/providers.
+type providerStatsReport struct {
+	Provider    string     `json:"provider"`
+	Calls       int        `json:"calls"`
+	Errors      int        `json:"errors"`
+	ErrorRate   float64    `json:"error_rate"`
+	AvgLatency  string     `json:"avg_latency"`
+	LastSuccess *time.Time `json:"last_success"`
+	LastError   *time.Time `json:"last_error,omitempty"`
+	LastErrMsg  string     `json:"last_error_message,omitempty"`
+	TotalCalls  int64      `json:"total_calls"`
+	TotalErrors int64      `json:"total_errors"`
+}
+
+func (s *providerStats) report(name string, now time.Time) providerStatsReport {
+	r := providerStatsReport{Provider: name, AvgLatency: "0s", TotalCalls: s.totalCalls, TotalErrors: s.totalErrors, LastErrMsg: s.lastErrMsg}
+	var latency time.Duration
+	for _, b := range s.buckets {
+		if now.Unix()/60-b.minute < statsWindow {
+			r.Calls += b.calls
+			r.Errors += b.errors
+			latency += b.latency
+		}
+	}
+	if r.Calls > 0 {
+		r.ErrorRate = float64(r.Errors) / float64(r.Calls)
+		r.AvgLatency = (latency / time.Duration(r.Calls)).Round(time.Millisecond).String()
+	}
+	if !s.lastSuccess.IsZero() {
+		t := s.lastSuccess.UTC()
+		r.LastSuccess = &t
+	}
+	if !s.lastError.IsZero() {
+		t := s.lastError.UTC()
+		r.LastError = &t
+	}
+	return r
+}
+
+// providerStatsHandler serves GET /stats/providers: per provider, the
+// calls, errors and average latency of the last statsWindow minutes, when
+// it last answered, and totals since start.
+func providerStatsHandler(mw multiWeatherProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		now := time.Now()
+		stats.Lock()
+		out := make([]providerStatsReport, len(mw))
+		for i, p := range mw {
+			s := stats.m[providerName(p)]
+			if s == nil {
+				s = &providerStats{}
+			}
+			out[i] = s.report(providerName(p), now)
+		}
+		stats.Unlock()
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"window":    (statsWindow * time.Minute).String(),
+			"providers": out,
+		})
+	}
+}
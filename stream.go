@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const wsPingInterval = 30 * time.Second // keeps idle streams open through proxies
+
+// streamRequest is a message from a /v1/stream client.
+type streamRequest struct {
+	Subscribe   []string `json:"subscribe"`
+	Unsubscribe []string `json:"unsubscribe"`
+}
+
+// streamEvent is a message to a /v1/stream client.
+type streamEvent struct {
+	Type   string         `json:"type"` // "update" or "error"
+	City   string         `json:"city,omitempty"`
+	Report *weatherReport `json:"report,omitempty"`
+	Error  string         `json:"error,omitempty"`
+}
+
+// stream serves /v1/stream over WebSocket. Clients subscribe to cities,
+// with ?cities= or by sending {"subscribe": ["Oslo", "Madrid,ES"]}, and
+// leave them with {"unsubscribe": [...]}. Each subscription gets the
+// current report at once and then an update whenever the refresher sees
+// the temperature change. ?units= and ?agg= apply to the whole stream.
+func stream(rf *refresher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		units, pol, err := reportOptions(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var initial []string
+		if r.URL.Query().Has("cities") {
+			if initial, err = batchCities(w, r); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		c, err := upgradeWebSocket(w, r)
+		if err != nil {
+			return
+		}
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			ping := time.NewTicker(wsPingInterval)
+			defer ping.Stop()
+			for {
+				select {
+				case <-ping.C:
+					c.writeFrame(wsPing, nil)
+				case <-shuttingDown:
+					c.close(wsGoingAway, "server shutting down")
+					return
+				case <-done:
+					return
+				}
+			}
+		}()
+
+		subs := map[string]func(){}
+		defer func() {
+			for _, cancel := range subs {
+				cancel()
+			}
+			c.conn.Close()
+		}()
+
+		subscribe := func(city string) {
+			if _, ok := subs[city]; ok {
+				return
+			}
+			if len(subs) >= maxBatchCities {
+				c.writeJSON(streamEvent{Type: "error", City: city, Error: fmt.Sprintf("at most %d subscriptions per stream", maxBatchCities)})
+				return
+			}
+			var loc Location
+			err := validCity(city)
+			if err == nil {
+				loc, err = parseCity(city, "")
+			}
+			if err != nil {
+				c.writeJSON(streamEvent{Type: "error", City: city, Error: err.Error()})
+				return
+			}
+
+			updates, cancel := rf.subscribe(loc, units, pol)
+			subs[city] = cancel
+			go func() {
+				sent, last := false, 0.0
+				for u := range updates {
+					if sent && u.report.Temp == last {
+						continue
+					}
+					sent, last = true, u.report.Temp
+					report := u.report
+					report.Age = time.Since(u.stored).Round(time.Second).String()
+					c.writeJSON(streamEvent{Type: "update", City: city, Report: &report})
+				}
+			}()
+		}
+
+		for _, city := range initial {
+			subscribe(city)
+		}
+		for {
+			msg, err := c.readMessage()
+			if err != nil {
+				if err != errWSClosed && !errors.Is(err, io.EOF) {
+					slog.DebugContext(r.Context(), "stream closed", "error", err)
+				}
+				return
+			}
+
+			var req streamRequest
+			if err := json.Unmarshal(msg, &req); err != nil {
+				c.writeJSON(streamEvent{Type: "error", Error: "invalid message: " + err.Error()})
+				continue
+			}
+			for _, city := range req.Subscribe {
+				subscribe(city)
+			}
+			for _, city := range req.Unsubscribe {
+				if cancel, ok := subs[city]; ok {
+					cancel()
+					delete(subs, city)
+				}
+			}
+		}
+	}
+}
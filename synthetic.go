@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// syntheticWeather generates plausible temperatures without any network
+// access. Every city gets a climate derived from the seed, on top of
+// which a seasonal cycle (coldest mid-January), a diurnal cycle (coldest
+// at 03:00 UTC) and some noise are laid. Values vary over time but are
+// reproducible for a given seed and instant.
+type syntheticWeather struct {
+	seed int64
+}
+
+func init() {
+	RegisterProvider("syntheticWeather", func(pc *providerConfig) (weatherProvider, error) {
+		return syntheticWeather{seed: pc.seed}, nil
+	})
+}
+
+func (w syntheticWeather) hash(city string, bucket int64) int64 {
+	h := fnv.New64a()
+	var b [16]byte
+	for i := 0; i < 8; i++ {
+		b[i] = byte(w.seed >> (8 * i))
+		b[8+i] = byte(bucket >> (8 * i))
+	}
+	h.Write(b[:])
+	h.Write([]byte(city))
+	return int64(h.Sum64())
+}
+
+func (w syntheticWeather) at(city string, t time.Time) float64 {
+	climate := rand.New(rand.NewSource(w.hash(city, 0)))
+	mean := -5 + 25*climate.Float64()
+	seasonal := 4 + 12*climate.Float64()
+	diurnal := 2 + 6*climate.Float64()
+
+	t = t.UTC()
+	day := float64(t.YearDay()-15) / 365.25
+	hour := (float64(t.Hour()) + float64(t.Minute())/60 - 3) / 24
+
+	// New noise every ten minutes, so consecutive requests agree.
+	noise := rand.New(rand.NewSource(w.hash(city, t.Unix()/600+1))).NormFloat64() * 0.5
+
+	return mean - seasonal*math.Cos(2*math.Pi*day) - diurnal*math.Cos(2*math.Pi*hour) + noise
+}
+
+func (w syntheticWeather) temperature(ctx context.Context, loc Location) (float64, error) {
+	if loc.Coord != nil {
+		return w.temperatureAt(ctx, *loc.Coord)
+	}
+	celsius := w.at(loc.query(), time.Now())
+	logReading(ctx, "syntheticWeather", loc, celsius)
+	return celsius, nil
+}
+
+// temperatureAt models temperature from position alone: warmer towards
+// the equator, with seasons opposite between hemispheres that grow
+// stronger towards the poles, and a diurnal cycle in local solar time.
+// Neighbouring points get similar values, which keeps grids smooth.
+func (w syntheticWeather) temperatureAt(ctx context.Context, coord Coord) (float64, error) {
+	t := time.Now().UTC()
+	lat := math.Abs(coord.Lat)
+
+	mean := 28 - 0.45*lat
+	seasonal := 0.25 * lat
+	if coord.Lat < 0 {
+		seasonal = -seasonal
+	}
+	day := float64(t.YearDay()-15) / 365.25
+	solar := float64(t.Hour()) + float64(t.Minute())/60 + coord.Lon/15
+	hour := (solar - 3) / 24
+
+	offset := rand.New(rand.NewSource(w.seed)).Float64()*4 - 2
+	return mean + offset - seasonal*math.Cos(2*math.Pi*day) - 4*math.Cos(2*math.Pi*hour), nil
+}
+
+// day reads the model at the warmest and coldest hours of a UTC day; the
+// condition is drawn per city and day.
+func (w syntheticWeather) day(city string, day time.Time) dailyForecast {
+	kinds := []string{"clear", "partly-cloudy", "cloudy", "rain", "fog"}
+	pick := rand.New(rand.NewSource(w.hash(city, -day.Unix()))).Intn(len(kinds))
+	return dailyForecast{
+		date:      day.Format("2006-01-02"),
+		high:      w.at(city, day.Add(15*time.Hour)),
+		low:       w.at(city, day.Add(3*time.Hour)),
+		condition: kinds[pick],
+	}
+}
+
+func (w syntheticWeather) forecast(ctx context.Context, city string, days int) ([]dailyForecast, error) {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	out := make([]dailyForecast, days)
+	for i := range out {
+		out[i] = w.day(city, today.AddDate(0, 0, i))
+	}
+	return out, nil
+}
+
+func (w syntheticWeather) history(ctx context.Context, city string, date time.Time) (dailyForecast, error) {
+	return w.day(city, date), nil
+}
+
+func (w syntheticWeather) hourly(ctx context.Context, city string, hours int) ([]hourlyForecast, error) {
+	now := time.Now().UTC().Truncate(time.Hour)
+	out := make([]hourlyForecast, hours)
+	for i := range out {
+		t := now.Add(time.Duration(i) * time.Hour)
+		out[i] = hourlyForecast{
+			time:          t,
+			temp:          w.at(city, t),
+			precipitation: rand.New(rand.NewSource(w.hash(city, -t.Unix()))).Float64(),
+		}
+	}
+	return out, nil
+}
+
+// observe fills in the rest of the conditions from noise that changes
+// every ten minutes, like the temperature. UV follows the sun, peaking at
+// 12:00 UTC.
+func (w syntheticWeather) observe(ctx context.Context, city string) (Observation, error) {
+	now := time.Now()
+	noise := rand.New(rand.NewSource(w.hash(city, now.Unix()/600+1)))
+	hour := float64(now.UTC().Hour()) + float64(now.UTC().Minute())/60
+	uv := math.Max(0, 8*math.Cos(math.Pi*(hour-12)/12)*(1-0.5*noise.Float64()))
+	return Observation{
+		UVIndex:       ptr(uv),
+		Temp:          ptr(w.at(city, now)),
+		Humidity:      ptr(40 + 55*noise.Float64()),
+		WindSpeed:     ptr(12 * noise.Float64()),
+		WindDirection: ptr(360 * noise.Float64()),
+		Pressure:      ptr(1013 + 12*noise.NormFloat64()),
+		CloudCover:    ptr(100 * noise.Float64()),
+		Visibility:    ptr(1 + 19*noise.Float64()),
+	}, nil
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	tileTTL      = 5 * time.Minute
+	maxTileCache = 1024
+	maxTileZoom  = 18
+)
+
+// owmTileLayers maps the layer names served under /tiles/ to
+// OpenWeatherMap map layers.
+var owmTileLayers = map[string]string{
+	"clouds":        "clouds_new",
+	"precipitation": "precipitation_new",
+	"temperature":   "temp_new",
+	"wind":          "wind_new",
+	"pressure":      "pressure_new",
+}
+
+type cachedTile struct {
+	body        []byte
+	contentType string
+	expires     time.Time
+}
+
+// tileProxy serves /tiles/{layer}/{z}/{x}/{y}.png from upstream map tile
+// services so that map UIs can overlay weather without holding provider
+// keys. The "radar" layer comes from RainViewer and needs no key; the
+// OpenWeatherMap layers are only available when owmKey is set. Tiles are
+// cached in memory for tileTTL.
+type tileProxy struct {
+	owmKey string
+
+	flights flightGroup // upstream fetches by tile key, and "radar"
+
+	mu           sync.Mutex
+	tiles        map[string]cachedTile
+	radar        string // URL prefix of the latest RainViewer radar frame
+	radarExpires time.Time
+}
+
+func newTileProxy(owmKey string) *tileProxy {
+	return &tileProxy{owmKey: owmKey, tiles: map[string]cachedTile{}}
+}
+
+// radarPrefix returns the URL prefix of the latest RainViewer radar
+// frame, refreshing it at most once per tileTTL. Concurrent refreshes
+// share one upstream request.
+func (t *tileProxy) radarPrefix(ctx context.Context) (string, error) {
+	t.mu.Lock()
+	if time.Now().Before(t.radarExpires) {
+		defer t.mu.Unlock()
+		return t.radar, nil
+	}
+	t.mu.Unlock()
+
+	v, err := t.flights.do(ctx, "radar", func(ctx context.Context) interface{} {
+		prefix, err := fetchRadarPrefix(ctx)
+		if err == nil {
+			t.mu.Lock()
+			t.radar, t.radarExpires = prefix, time.Now().Add(tileTTL)
+			t.mu.Unlock()
+		}
+		return flightResult{prefix, err}
+	})
+	if err != nil {
+		return "", err
+	}
+	res := v.(flightResult)
+	if res.err != nil {
+		return "", res.err
+	}
+	return res.val.(string), nil
+}
+
+// flightResult carries a value and error through flightGroup.do.
+type flightResult struct {
+	val interface{}
+	err error
+}
+
+func fetchRadarPrefix(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL("rainViewer")+"/public/weather-maps.json", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	resp, err := upstreamClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("rainviewer: %s", resp.Status)
+	}
+
+	var d struct {
+		Host  string `json:"host"`
+		Radar struct {
+			Past []struct {
+				Path string `json:"path"`
+			} `json:"past"`
+		} `json:"radar"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&d); err != nil {
+		return "", err
+	}
+	if len(d.Radar.Past) == 0 {
+		return "", fmt.Errorf("rainviewer: no radar frames")
+	}
+	return d.Host + d.Radar.Past[len(d.Radar.Past)-1].Path, nil
+}
+
+func (t *tileProxy) upstreamURL(ctx context.Context, layer string, z, x, y int) (string, error) {
+	if layer == "radar" {
+		prefix, err := t.radarPrefix(ctx)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s/256/%d/%d/%d/2/1_1.png", prefix, z, x, y), nil
+	}
+	return fmt.Sprintf("%s/map/%s/%d/%d/%d.png?appid=%s", baseURL("openWeatherMapTiles"), owmTileLayers[layer], z, x, y, t.owmKey), nil
+}
+
+func (t *tileProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasSuffix(r.PathValue("y"), ".png") {
+		http.Error(w, "want "+apiVersion+"/tiles/{layer}/{z}/{x}/{y}.png", http.StatusBadRequest)
+		return
+	}
+
+	layer := r.PathValue("layer")
+	z, errZ := strconv.Atoi(r.PathValue("z"))
+	x, errX := strconv.Atoi(r.PathValue("x"))
+	y, errY := strconv.Atoi(strings.TrimSuffix(r.PathValue("y"), ".png"))
+	if errZ != nil || errX != nil || errY != nil || z < 0 || z > maxTileZoom || x < 0 || y < 0 || x >= 1<<z || y >= 1<<z {
+		http.Error(w, "invalid tile coordinates", http.StatusBadRequest)
+		return
+	}
+	if _, ok := owmTileLayers[layer]; layer != "radar" && (!ok || t.owmKey == "") {
+		http.Error(w, "unknown tile layer "+layer, http.StatusNotFound)
+		return
+	}
+
+	key := fmt.Sprintf("%s/%d/%d/%d", layer, z, x, y)
+	t.mu.Lock()
+	tile, ok := t.tiles[key]
+	t.mu.Unlock()
+
+	if !ok || time.Now().After(tile.expires) {
+		// Concurrent misses for a tile share one upstream request.
+		v, err := t.flights.do(r.Context(), key, func(ctx context.Context) interface{} {
+			url, err := t.upstreamURL(ctx, layer, z, x, y)
+			if err != nil {
+				return flightResult{nil, err}
+			}
+			tile, err := fetchTile(ctx, url)
+			if err == nil {
+				t.store(key, tile)
+			}
+			return flightResult{tile, err}
+		})
+		if err != nil {
+			return // the client went away
+		}
+		res := v.(flightResult)
+		if res.err != nil {
+			http.Error(w, publicError(res.err), http.StatusBadGateway)
+			return
+		}
+		tile = res.val.(cachedTile)
+	}
+
+	w.Header().Set("Content-Type", tile.contentType)
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(time.Until(tile.expires).Seconds())))
+	w.Write(tile.body)
+}
+
+func fetchTile(ctx context.Context, url string) (cachedTile, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return cachedTile{}, fmt.Errorf("tile upstream unavailable")
+	}
+	req.Header.Set("User-Agent", userAgent)
+	resp, err := upstreamClient.Do(req)
+	if err != nil {
+		// The error would include the URL and with it the API key.
+		return cachedTile{}, fmt.Errorf("tile upstream unavailable")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return cachedTile{}, fmt.Errorf("tile upstream: %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return cachedTile{}, fmt.Errorf("tile upstream unavailable")
+	}
+
+	ct := resp.Header.Get("Content-Type")
+	if ct == "" {
+		ct = "image/png"
+	}
+	return cachedTile{body: body, contentType: ct, expires: time.Now().Add(tileTTL)}, nil
+}
+
+// store caches a tile, evicting expired tiles and then the tile closest
+// to expiry when the cache is full.
+func (t *tileProxy) store(key string, tile cachedTile) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.tiles) >= maxTileCache {
+		now := time.Now()
+		oldest := ""
+		for k, c := range t.tiles {
+			if now.After(c.expires) {
+				delete(t.tiles, k)
+			} else if oldest == "" || c.expires.Before(t.tiles[oldest].expires) {
+				oldest = k
+			}
+		}
+		if len(t.tiles) >= maxTileCache {
+			delete(t.tiles, oldest)
+		}
+	}
+	t.tiles[key] = tile
+}
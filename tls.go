@@ -0,0 +1,80 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// certReloadInterval is how often the certificate files are checked for
+// renewal.
+const certReloadInterval = time.Minute
+
+// certReloader serves the certificate in certFile and keyFile, reloading
+// it when the files change, so that renewals by certbot or a similar
+// ACME client take effect without a restart.
+type certReloader struct {
+	certFile, keyFile string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+	checked time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	c := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *certReloader) load() error {
+	fi, err := os.Stat(c.certFile)
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+	c.cert, c.modTime = &cert, fi.ModTime()
+	return nil
+}
+
+func (c *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Since(c.checked) >= certReloadInterval {
+		c.checked = time.Now()
+		if fi, err := os.Stat(c.certFile); err == nil && !fi.ModTime().Equal(c.modTime) {
+			if err := c.load(); err != nil {
+				slog.Error("reloading certificate, keeping the old one", "file", c.certFile, "error", err)
+			} else {
+				slog.Info("reloaded certificate", "file", c.certFile)
+			}
+		}
+	}
+	return c.cert, nil
+}
+
+// serverTLS returns the TLS config for serving HTTPS, or nil to serve
+// plain HTTP. There is no built-in ACME client: obtain certificates with
+// certbot or similar, and renewals are picked up by the reloader.
+func serverTLS(certFile, keyFile string) (*tls.Config, error) {
+	switch {
+	case certFile == "" && keyFile == "":
+		return nil, nil
+	case certFile == "" || keyFile == "":
+		return nil, fmt.Errorf("-tls-cert and -tls-key must be given together")
+	}
+	c, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{MinVersion: tls.VersionTLS12, GetCertificate: c.getCertificate}, nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// parseTOML reads the subset of TOML used by config files: comments,
+// [table] and [dotted.table] headers, and key = value lines whose value
+// is a string, number, boolean or a one-line array of those. Values are
+// returned keyed by their full dotted name, e.g.
+// "providers.pirateWeather.timeout". Every table header is also recorded,
+// as an empty map, so that empty tables are visible. Numbers are float64.
+func parseTOML(r io.Reader) (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+	table := ""
+
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		if line[0] == '[' {
+			end := strings.Index(line, "]")
+			if end < 0 || strings.TrimSpace(line[end+1:]) != "" && !strings.HasPrefix(strings.TrimSpace(line[end+1:]), "#") {
+				return nil, fmt.Errorf("line %d: invalid table header", n)
+			}
+			table = strings.TrimSpace(line[1:end])
+			if _, dup := values[table]; dup {
+				return nil, fmt.Errorf("line %d: duplicate table %s", n, table)
+			}
+			values[table] = map[string]interface{}{}
+			table += "."
+			continue
+		}
+
+		eq := strings.Index(line, "=")
+		if eq < 0 {
+			return nil, fmt.Errorf("line %d: expected key = value", n)
+		}
+		key := strings.Trim(strings.TrimSpace(line[:eq]), `"`)
+
+		v, rest, err := parseTOMLValue(strings.TrimSpace(line[eq+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+		if rest = strings.TrimSpace(rest); rest != "" && rest[0] != '#' {
+			return nil, fmt.Errorf("line %d: unexpected %q after value", n, rest)
+		}
+
+		if _, dup := values[table+key]; dup {
+			return nil, fmt.Errorf("line %d: duplicate key %s", n, table+key)
+		}
+		values[table+key] = v
+	}
+
+	return values, sc.Err()
+}
+
+// parseTOMLValue parses the value at the start of s and returns it with
+// the unparsed remainder.
+func parseTOMLValue(s string) (interface{}, string, error) {
+	switch {
+	case s == "":
+		return nil, "", fmt.Errorf("missing value")
+
+	case s[0] == '"':
+		for i := 1; i < len(s); i++ {
+			switch s[i] {
+			case '\\':
+				i++
+			case '"':
+				v, err := strconv.Unquote(s[:i+1])
+				return v, s[i+1:], err
+			}
+		}
+		return nil, "", fmt.Errorf("unterminated string")
+
+	case s[0] == '\'':
+		end := strings.Index(s[1:], "'")
+		if end < 0 {
+			return nil, "", fmt.Errorf("unterminated string")
+		}
+		return s[1 : end+1], s[end+2:], nil
+
+	case s[0] == '[':
+		list := []interface{}{}
+		s = strings.TrimSpace(s[1:])
+		for {
+			if s != "" && s[0] == ']' {
+				return list, s[1:], nil
+			}
+			v, rest, err := parseTOMLValue(s)
+			if err != nil {
+				return nil, "", err
+			}
+			list = append(list, v)
+
+			s = strings.TrimSpace(rest)
+			if s != "" && s[0] == ',' {
+				s = strings.TrimSpace(s[1:])
+			} else if s == "" || s[0] != ']' {
+				return nil, "", fmt.Errorf("unterminated array")
+			}
+		}
+
+	case strings.HasPrefix(s, "true"):
+		return true, s[4:], nil
+
+	case strings.HasPrefix(s, "false"):
+		return false, s[5:], nil
+	}
+
+	end := strings.IndexAny(s, " \t,]#")
+	if end < 0 {
+		end = len(s)
+	}
+	f, err := strconv.ParseFloat(strings.ReplaceAll(s[:end], "_", ""), 64)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid value %q", s[:end])
+	}
+	return f, s[end:], nil
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// tomorrowIo is Tomorrow.io's timeline API, which serves current
+// conditions and forecasts from one endpoint selected by timestep.
+type tomorrowIo struct {
+	apiKey string
+}
+
+func init() {
+	RegisterProvider("tomorrowIo", func(pc *providerConfig) (weatherProvider, error) {
+		if pc.apiKey == "" {
+			return nil, errNoAPIKey
+		}
+		return tomorrowIo{apiKey: pc.apiKey}, nil
+	})
+}
+
+// tomorrowValues is the union of the fields requested from the timeline
+// API; each timestep fills in its own.
+type tomorrowValues struct {
+	Temp           *float64 `json:"temperature"`
+	Humidity       *float64 `json:"humidity"`
+	WindSpeed      *float64 `json:"windSpeed"`
+	WindDirection  *float64 `json:"windDirection"`
+	Pressure       *float64 `json:"pressureSeaLevel"`
+	CloudCover     *float64 `json:"cloudCover"`
+	Visibility     *float64 `json:"visibility"`
+	UVIndex        *float64 `json:"uvIndex"`
+	TemperatureMax float64  `json:"temperatureMax"`
+	TemperatureMin float64  `json:"temperatureMin"`
+	WeatherCode    int      `json:"weatherCodeDay"`
+}
+
+type tomorrowInterval struct {
+	StartTime string         `json:"startTime"`
+	Values    tomorrowValues `json:"values"`
+}
+
+// tomorrowCondition maps Tomorrow.io weather codes onto the response
+// vocabulary. Codes are grouped by thousands: 1xxx sky cover, 2xxx fog,
+// 4xxx rain, 5xxx snow, 6xxx freezing rain, 7xxx ice pellets, 8xxx
+// thunderstorms.
+func tomorrowCondition(code int) string {
+	switch {
+	case code == 1000 || code == 1100:
+		return "clear"
+	case code == 1101:
+		return "partly-cloudy"
+	case code == 1001 || code == 1102:
+		return "cloudy"
+	case code/1000 == 2:
+		return "fog"
+	case code/1000 == 4:
+		return "rain"
+	case code/1000 == 5:
+		return "snow"
+	case code/1000 == 6 || code/1000 == 7:
+		return "sleet"
+	case code/1000 == 8:
+		return "thunderstorm"
+	}
+	return "unknown"
+}
+
+// timeline fetches the intervals of one timestep at coord.
+func (w tomorrowIo) timeline(ctx context.Context, coord Coord, fields, timestep string) ([]tomorrowInterval, error) {
+	resp, err := httpGet(ctx, baseURL("tomorrowIo")+"/v4/timelines?apikey="+w.apiKey+"&units=metric&timezone=auto"+
+		"&location="+FloatToString(coord.Lat)+","+FloatToString(coord.Lon)+"&fields="+fields+"&timesteps="+timestep)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("tomorrowIo: %s", resp.Status)
+	}
+
+	var d struct {
+		Data struct {
+			Timelines []struct {
+				Intervals []tomorrowInterval `json:"intervals"`
+			} `json:"timelines"`
+		} `json:"data"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&d); err != nil {
+		return nil, err
+	}
+	if len(d.Data.Timelines) == 0 || len(d.Data.Timelines[0].Intervals) == 0 {
+		return nil, fmt.Errorf("tomorrowIo: empty timeline")
+	}
+	return d.Data.Timelines[0].Intervals, nil
+}
+
+func (w tomorrowIo) current(ctx context.Context, coord Coord) (Observation, error) {
+	intervals, err := w.timeline(ctx, coord, "temperature,humidity,windSpeed,windDirection,pressureSeaLevel,cloudCover,visibility,uvIndex", "current")
+	if err != nil {
+		return Observation{}, err
+	}
+
+	v := intervals[0].Values
+	return Observation{
+		Temp:          v.Temp,
+		Humidity:      v.Humidity,
+		WindSpeed:     v.WindSpeed,
+		WindDirection: v.WindDirection,
+		Pressure:      v.Pressure,
+		CloudCover:    v.CloudCover,
+		Visibility:    v.Visibility,
+		UVIndex:       v.UVIndex,
+	}, nil
+}
+
+func (w tomorrowIo) temperature(ctx context.Context, loc Location) (float64, error) {
+	var coord Coord
+	if loc.Coord != nil {
+		coord = *loc.Coord
+	} else {
+		var err error
+		if coord, err = locateCoord(ctx, loc.query()); err != nil {
+			return 0, err
+		}
+	}
+
+	celsius, err := w.temperatureAt(ctx, coord)
+	if err != nil {
+		return 0, err
+	}
+
+	logReading(ctx, "tomorrowIo", loc, celsius)
+	return celsius, nil
+}
+
+func (w tomorrowIo) temperatureAt(ctx context.Context, coord Coord) (float64, error) {
+	o, err := w.current(ctx, coord)
+	if err != nil {
+		return 0, err
+	}
+	if o.Temp == nil {
+		return 0, fmt.Errorf("tomorrowIo: no temperature")
+	}
+	return *o.Temp, nil
+}
+
+func (w tomorrowIo) observe(ctx context.Context, city string) (Observation, error) {
+	coord, err := locateCoord(ctx, city)
+	if err != nil {
+		return Observation{}, err
+	}
+	return w.current(ctx, coord)
+}
+
+// forecast reads the daily timestep, which starts with today and runs
+// for up to five days on the free plan.
+func (w tomorrowIo) forecast(ctx context.Context, city string, days int) ([]dailyForecast, error) {
+	coord, err := locateCoord(ctx, city)
+	if err != nil {
+		return nil, err
+	}
+
+	intervals, err := w.timeline(ctx, coord, "temperatureMax,temperatureMin,weatherCodeDay", "1d")
+	if err != nil {
+		return nil, err
+	}
+
+	var out []dailyForecast
+	for _, in := range intervals {
+		if len(out) == days {
+			break
+		}
+		if len(in.StartTime) < len("2006-01-02") {
+			return nil, fmt.Errorf("tomorrowIo: bad startTime %q", in.StartTime)
+		}
+		out = append(out, dailyForecast{
+			date:      in.StartTime[:len("2006-01-02")],
+			high:      in.Values.TemperatureMax,
+			low:       in.Values.TemperatureMin,
+			condition: tomorrowCondition(in.Values.WeatherCode),
+		})
+	}
+	return out, nil
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tracing follows the OpenTelemetry model closely enough for any OTLP
+// collector: spans carry W3C trace context, which is continued from an
+// incoming traceparent header and passed on to upstreams, and are
+// exported in batches as OTLP/HTTP JSON. It is off unless an endpoint is
+// configured.
+
+const (
+	spanInternal = 1
+	spanServer   = 2
+	spanClient   = 3
+
+	traceBatch    = 512
+	traceInterval = 5 * time.Second
+)
+
+// tracer exports finished spans; nil while tracing is off.
+var tracer *spanExporter
+
+type span struct {
+	traceID [16]byte
+	spanID  [8]byte
+	parent  [8]byte
+	name    string
+	kind    int
+	start   time.Time
+	end     time.Time
+	attrs   map[string]interface{}
+	err     string
+
+	mu sync.Mutex
+}
+
+type spanKey struct{}
+
+// startSpan starts a child of the span in ctx, or a new trace. Without a
+// tracer it returns ctx and a nil span, on which every method is a no-op.
+func startSpan(ctx context.Context, name string, kind int) (context.Context, *span) {
+	if tracer == nil {
+		return ctx, nil
+	}
+	s := &span{name: name, kind: kind, start: time.Now(), attrs: map[string]interface{}{}}
+	if parent, ok := ctx.Value(spanKey{}).(*span); ok {
+		s.traceID, s.parent = parent.traceID, parent.spanID
+	} else {
+		rand.Read(s.traceID[:])
+	}
+	rand.Read(s.spanID[:])
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+func (s *span) set(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.attrs[key] = value
+	s.mu.Unlock()
+}
+
+// finish ends the span, marking it failed if err is not nil, and queues
+// it for export.
+func (s *span) finish(err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.end = time.Now()
+	if err != nil {
+		s.err = publicError(err)
+	}
+	s.mu.Unlock()
+	tracer.add(s)
+}
+
+// traceparent formats the span as a W3C traceparent header value.
+func (s *span) traceparent() string {
+	return "00-" + hex.EncodeToString(s.traceID[:]) + "-" + hex.EncodeToString(s.spanID[:]) + "-01"
+}
+
+// remoteParent is a stand-in span for the caller's side of an incoming
+// traceparent, so that our spans join the caller's trace.
+func remoteParent(header string) (*span, bool) {
+	parts := strings.Split(header, "-")
+	if len(parts) != 4 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return nil, false
+	}
+	s := &span{}
+	if _, err := hex.Decode(s.traceID[:], []byte(parts[1])); err != nil {
+		return nil, false
+	}
+	if _, err := hex.Decode(s.spanID[:], []byte(parts[2])); err != nil {
+		return nil, false
+	}
+	return s, s.traceID != [16]byte{}
+}
+
+// traced wraps every request in a server span.
+type traced struct {
+	next http.Handler
+}
+
+func (t traced) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if tracer == nil {
+		t.next.ServeHTTP(w, r)
+		return
+	}
+	ctx := r.Context()
+	if parent, ok := remoteParent(r.Header.Get("traceparent")); ok {
+		ctx = context.WithValue(ctx, spanKey{}, parent)
+	}
+	// Named after the method alone until routed renames it.
+	ctx, s := startSpan(ctx, r.Method, spanServer)
+	s.set("http.method", r.Method)
+	s.set("http.target", r.URL.Path)
+	s.set("http.request_id", requestID(ctx))
+
+	rec := &statusRecorder{ResponseWriter: w}
+	t.next.ServeHTTP(rec, r.WithContext(ctx))
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	s.set("http.status_code", rec.status)
+	var err error
+	if rec.status >= 500 {
+		err = fmt.Errorf("%s", http.StatusText(rec.status))
+	}
+	s.finish(err)
+}
+
+// routed names the server span after the pattern the mux matched, such
+// as "GET /v1/weather/{city}". The mux sets r.Pattern on the request it is
+// handed, so this has to sit right around it, inside the other middleware.
+type routed struct {
+	mux *http.ServeMux
+}
+
+func (rt routed) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		s, ok := r.Context().Value(spanKey{}).(*span)
+		if !ok || r.Pattern == "" {
+			return
+		}
+		route := r.Pattern
+		if _, path, ok := strings.Cut(route, " "); ok {
+			route = path
+		}
+		s.mu.Lock()
+		s.name = r.Method + " " + route
+		s.attrs["http.route"] = route
+		s.mu.Unlock()
+	}()
+	rt.mux.ServeHTTP(w, r)
+}
+
+// spanExporter batches finished spans and posts them to an OTLP/HTTP
+// endpoint. Spans are dropped rather than block when it falls behind.
+type spanExporter struct {
+	url     string
+	service string
+	client  *http.Client
+	queue   chan *span
+}
+
+func startTracing(endpoint, service string) {
+	tracer = &spanExporter{
+		url:     strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		service: service,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		queue:   make(chan *span, 4*traceBatch),
+	}
+	go tracer.run()
+}
+
+func (e *spanExporter) add(s *span) {
+	select {
+	case e.queue <- s:
+	default:
+	}
+}
+
+func (e *spanExporter) run() {
+	tick := time.NewTicker(traceInterval)
+	var batch []*span
+	for {
+		select {
+		case s := <-e.queue:
+			if batch = append(batch, s); len(batch) < traceBatch {
+				continue
+			}
+		case <-tick.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		if err := e.export(batch); err != nil {
+			slog.Warn("trace export", "spans", len(batch), "error", err)
+		}
+		batch = nil
+	}
+}
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+}
+
+type otlpAttr struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+func otlpAttrs(m map[string]interface{}) []otlpAttr {
+	var out []otlpAttr
+	for k, v := range m {
+		var val otlpValue
+		switch v := v.(type) {
+		case int:
+			s := strconv.Itoa(v)
+			val.IntValue = &s
+		case float64:
+			val.DoubleValue = &v
+		case bool:
+			val.BoolValue = &v
+		default:
+			s := fmt.Sprint(v)
+			val.StringValue = &s
+		}
+		out = append(out, otlpAttr{k, val})
+	}
+	return out
+}
+
+func (e *spanExporter) export(batch []*span) error {
+	type otlpStatus struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	}
+	type otlpSpan struct {
+		TraceID      string     `json:"traceId"`
+		SpanID       string     `json:"spanId"`
+		ParentSpanID string     `json:"parentSpanId,omitempty"`
+		Name         string     `json:"name"`
+		Kind         int        `json:"kind"`
+		Start        string     `json:"startTimeUnixNano"`
+		End          string     `json:"endTimeUnixNano"`
+		Attributes   []otlpAttr `json:"attributes,omitempty"`
+		Status       otlpStatus `json:"status"`
+	}
+
+	spans := make([]otlpSpan, len(batch))
+	for i, s := range batch {
+		s.mu.Lock()
+		o := otlpSpan{
+			TraceID:    hex.EncodeToString(s.traceID[:]),
+			SpanID:     hex.EncodeToString(s.spanID[:]),
+			Name:       s.name,
+			Kind:       s.kind,
+			Start:      strconv.FormatInt(s.start.UnixNano(), 10),
+			End:        strconv.FormatInt(s.end.UnixNano(), 10),
+			Attributes: otlpAttrs(s.attrs),
+			Status:     otlpStatus{Code: 1},
+		}
+		if s.parent != [8]byte{} {
+			o.ParentSpanID = hex.EncodeToString(s.parent[:])
+		}
+		if s.err != "" {
+			o.Status = otlpStatus{Code: 2, Message: s.err}
+		}
+		s.mu.Unlock()
+		spans[i] = o
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": otlpAttrs(map[string]interface{}{"service.name": e.service}),
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]string{"name": "hello_world"},
+				"spans": spans,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	resp, err := e.client.Post(e.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", e.url, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// unitSystem selects how every numeric field of a response is expressed.
+// Providers always report metric values; conversion happens only here.
+type unitSystem string
+
+const (
+	metric   unitSystem = "metric"   // °C, m/s, km
+	imperial unitSystem = "imperial" // °F, mph, miles
+	si       unitSystem = "si"       // K, m/s, km
+)
+
+// parseUnitSystem accepts a unit system name, or the temperature unit
+// shorthands c, f and k for metric, imperial and si.
+func parseUnitSystem(s string) (unitSystem, error) {
+	switch strings.ToLower(s) {
+	case "", "c", "metric":
+		return metric, nil
+	case "f", "imperial":
+		return imperial, nil
+	case "k", "si":
+		return si, nil
+	}
+	return "", fmt.Errorf("unknown units %q, want c, f, k, metric, imperial or si", s)
+}
+
+// temperatureUnit is the symbol of the temperature unit, echoed in
+// responses next to converted temperatures.
+func (u unitSystem) temperatureUnit() string {
+	switch u {
+	case imperial:
+		return "°F"
+	case si:
+		return "K"
+	}
+	return "°C"
+}
+
+func CelsiusToFahrenheit(input_num float64) float64 {
+	return input_num*9/5 + 32
+}
+
+func CelsiusToKelvin(input_num float64) float64 {
+	return input_num + 273.15
+}
+
+// temperature converts a Celsius reading into the unit system.
+func (u unitSystem) temperature(celsius float64) float64 {
+	switch u {
+	case imperial:
+		return CelsiusToFahrenheit(celsius)
+	case si:
+		return CelsiusToKelvin(celsius)
+	}
+	return celsius
+}
+
+// windSpeedUnit is the symbol of the wind speed unit, echoed next to
+// converted wind speeds.
+func (u unitSystem) windSpeedUnit() string {
+	if u == imperial {
+		return "mph"
+	}
+	return "m/s"
+}
+
+// windSpeed converts a wind speed in m/s into the unit system.
+func (u unitSystem) windSpeed(ms float64) float64 {
+	if u == imperial {
+		return ms * 2.236936
+	}
+	return ms
+}
+
+// visibilityUnit is the symbol of the visibility unit, echoed next to
+// converted visibilities.
+func (u unitSystem) visibilityUnit() string {
+	if u == imperial {
+		return "mi"
+	}
+	return "km"
+}
+
+// visibility converts a visibility in km into the unit system.
+func (u unitSystem) visibility(km float64) float64 {
+	if u == imperial {
+		return km / 1.609344
+	}
+	return km
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+)
+
+// upstream holds the base URLs of a provider API per deployment
+// environment. None of the current providers publish a public sandbox,
+// so sandbox URLs are supplied by the deployment through
+// <NAME>_SANDBOX_URL environment variables, e.g.
+// OPENWEATHERMAP_SANDBOX_URL=http://owm-mock.staging:8080.
+type upstream struct {
+	production string
+	sandbox    string
+	provider   string // the provider this API serves, when not named after it
+}
+
+var upstreams = map[string]*upstream{
+	"openWeatherMap":           {production: "https://api.openweathermap.org"},
+	"weatherUnderground":       {production: "https://api.wunderground.com"},
+	"pirateWeather":            {production: "https://api.pirateweather.net"},
+	"pirateWeatherTimeMachine": {production: "https://timemachine.pirateweather.net", provider: "pirateWeather"},
+	"nationalWeatherService":   {production: "https://api.weather.gov"},
+	"nominatim":                {production: "https://nominatim.openstreetmap.org"},
+	"openMeteo":                {production: "https://api.open-meteo.com"},
+	"openMeteoArchive":         {production: "https://archive-api.open-meteo.com", provider: "openMeteo"},
+	"metNo":                    {production: "https://api.met.no"},
+	"accuWeather":              {production: "https://dataservice.accuweather.com"},
+	"tomorrowIo":               {production: "https://api.tomorrow.io"},
+	"weatherAPI":               {production: "https://api.weatherapi.com"},
+	"visualCrossing":           {production: "https://weather.visualcrossing.com"},
+	"openMeteoGeocoding":       {production: "https://geocoding-api.open-meteo.com"},
+	"openWeatherMapTiles":      {production: "https://tile.openweathermap.org", provider: "openWeatherMap"},
+	"rainViewer":               {production: "https://api.rainviewer.com"},
+}
+
+// environment is the deployment environment selected with -env.
+var environment = "production"
+
+// selectEnvironment switches the upstreams to env. Choosing sandbox
+// fails unless every provider in mw has a sandbox URL, so a staging
+// deployment never spends production quota on them by accident. The
+// other upstreams, such as geocoders and disabled providers, stay on
+// production when they have none, with a warning.
+func selectEnvironment(env string, mw multiWeatherProvider) error {
+	switch env {
+	case "production":
+	case "sandbox":
+		enabled := map[string]bool{}
+		for _, p := range mw {
+			enabled[providerName(p)] = true
+		}
+
+		var names, missing []string
+		for name := range upstreams {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			u := upstreams[name]
+			key := strings.ToUpper(name) + "_SANDBOX_URL"
+			u.sandbox = strings.TrimSuffix(os.Getenv(key), "/")
+			if u.sandbox != "" {
+				continue
+			}
+			provider := name
+			if u.provider != "" {
+				provider = u.provider
+			}
+			if enabled[provider] {
+				missing = append(missing, key)
+				continue
+			}
+			slog.Warn("no sandbox URL, using production", "upstream", name, "env", key)
+			u.sandbox = u.production
+		}
+		if len(missing) > 0 {
+			return fmt.Errorf("sandbox environment needs %s", strings.Join(missing, ", "))
+		}
+	default:
+		return fmt.Errorf("unknown environment %q, want production or sandbox", env)
+	}
+
+	environment = env
+	return nil
+}
+
+// baseURL returns the base URL of the named provider API in the selected
+// environment.
+func baseURL(name string) string {
+	u := upstreams[name]
+	if environment == "sandbox" {
+		return u.sandbox
+	}
+	return u.production
+}
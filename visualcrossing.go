@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// visualCrossing is the Visual Crossing timeline API. Current
+// conditions, forecasts and history all come from the same endpoint,
+// selected by the date range in the path.
+type visualCrossing struct {
+	apiKey string
+}
+
+func init() {
+	RegisterProvider("visualCrossing", func(pc *providerConfig) (weatherProvider, error) {
+		if pc.apiKey == "" {
+			return nil, errNoAPIKey
+		}
+		return visualCrossing{apiKey: pc.apiKey}, nil
+	})
+}
+
+type visualCrossingDay struct {
+	Date string  `json:"datetime"`
+	High float64 `json:"tempmax"`
+	Low  float64 `json:"tempmin"`
+	Icon string  `json:"icon"`
+}
+
+type visualCrossingResponse struct {
+	Current struct {
+		Temp          *float64 `json:"temp"`
+		Humidity      *float64 `json:"humidity"`
+		WindSpeed     *float64 `json:"windspeed"` // km/h
+		WindDirection *float64 `json:"winddir"`
+		Pressure      *float64 `json:"pressure"`
+		CloudCover    *float64 `json:"cloudcover"`
+		Visibility    *float64 `json:"visibility"`
+		UVIndex       *float64 `json:"uvindex"`
+	} `json:"currentConditions"`
+	Days []visualCrossingDay `json:"days"`
+}
+
+func (d visualCrossingDay) daily() dailyForecast {
+	return dailyForecast{date: d.Date, high: d.High, low: d.Low, condition: normalizeCondition(d.Icon)}
+}
+
+// timeline fetches span, a path suffix such as "/next7days" or
+// "/2020-01-31", for coord; include selects the sections returned.
+func (w visualCrossing) timeline(ctx context.Context, coord Coord, span, include string) (visualCrossingResponse, error) {
+	var d visualCrossingResponse
+	resp, err := httpGet(ctx, baseURL("visualCrossing")+"/VisualCrossingWebServices/rest/services/timeline/"+
+		FloatToString(coord.Lat)+","+FloatToString(coord.Lon)+span+"?unitGroup=metric&iconSet=icons1&include="+include+"&key="+w.apiKey)
+	if err != nil {
+		return d, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return d, fmt.Errorf("visualCrossing: %s", resp.Status)
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&d)
+	return d, err
+}
+
+func (w visualCrossing) current(ctx context.Context, coord Coord) (Observation, error) {
+	d, err := w.timeline(ctx, coord, "/today", "current")
+	if err != nil {
+		return Observation{}, err
+	}
+
+	c := d.Current
+	o := Observation{
+		Temp:          c.Temp,
+		Humidity:      c.Humidity,
+		WindDirection: c.WindDirection,
+		Pressure:      c.Pressure,
+		CloudCover:    c.CloudCover,
+		Visibility:    c.Visibility,
+		UVIndex:       c.UVIndex,
+	}
+	if c.WindSpeed != nil {
+		o.WindSpeed = ptr(*c.WindSpeed / 3.6)
+	}
+	return o, nil
+}
+
+func (w visualCrossing) temperature(ctx context.Context, loc Location) (float64, error) {
+	var coord Coord
+	if loc.Coord != nil {
+		coord = *loc.Coord
+	} else {
+		var err error
+		if coord, err = locateCoord(ctx, loc.query()); err != nil {
+			return 0, err
+		}
+	}
+
+	celsius, err := w.temperatureAt(ctx, coord)
+	if err != nil {
+		return 0, err
+	}
+
+	logReading(ctx, "visualCrossing", loc, celsius)
+	return celsius, nil
+}
+
+func (w visualCrossing) temperatureAt(ctx context.Context, coord Coord) (float64, error) {
+	o, err := w.current(ctx, coord)
+	if err != nil {
+		return 0, err
+	}
+	if o.Temp == nil {
+		return 0, fmt.Errorf("visualCrossing: no temperature")
+	}
+	return *o.Temp, nil
+}
+
+func (w visualCrossing) observe(ctx context.Context, city string) (Observation, error) {
+	coord, err := locateCoord(ctx, city)
+	if err != nil {
+		return Observation{}, err
+	}
+	return w.current(ctx, coord)
+}
+
+func (w visualCrossing) forecast(ctx context.Context, city string, days int) ([]dailyForecast, error) {
+	coord, err := locateCoord(ctx, city)
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := w.timeline(ctx, coord, fmt.Sprintf("/next%ddays", days-1), "days")
+	if err != nil {
+		return nil, err
+	}
+
+	var out []dailyForecast
+	for _, day := range d.Days {
+		if len(out) == days {
+			break
+		}
+		out = append(out, day.daily())
+	}
+	return out, nil
+}
+
+func (w visualCrossing) history(ctx context.Context, city string, date time.Time) (dailyForecast, error) {
+	coord, err := locateCoord(ctx, city)
+	if err != nil {
+		return dailyForecast{}, err
+	}
+
+	d, err := w.timeline(ctx, coord, "/"+date.Format("2006-01-02"), "days")
+	if err != nil {
+		return dailyForecast{}, err
+	}
+	if len(d.Days) == 0 {
+		return dailyForecast{}, fmt.Errorf("visualCrossing: no data for %s", date.Format("2006-01-02"))
+	}
+	return d.Days[0].daily(), nil
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// weatherAPI is WeatherAPI.com. Its forecast endpoint returns current
+// conditions along with the daily forecast, so one call answers
+// /weather/, /conditions/ and /forecast/ for a place; the response is
+// kept for weatherAPITTL and shared between them.
+type weatherAPI struct {
+	apiKey string
+}
+
+func init() {
+	RegisterProvider("weatherAPI", func(pc *providerConfig) (weatherProvider, error) {
+		if pc.apiKey == "" {
+			return nil, errNoAPIKey
+		}
+		return weatherAPI{apiKey: pc.apiKey}, nil
+	})
+}
+
+const weatherAPITTL = 10 * time.Minute
+
+type weatherAPIResponse struct {
+	Current struct {
+		TempC      *float64 `json:"temp_c"`
+		Humidity   *float64 `json:"humidity"`
+		WindKph    *float64 `json:"wind_kph"`
+		WindDegree *float64 `json:"wind_degree"`
+		PressureMb *float64 `json:"pressure_mb"`
+		Cloud      *float64 `json:"cloud"`
+		VisKm      *float64 `json:"vis_km"`
+		UV         *float64 `json:"uv"`
+	} `json:"current"`
+	Forecast struct {
+		Forecastday []struct {
+			Date string `json:"date"`
+			Day  struct {
+				MaxtempC  float64 `json:"maxtemp_c"`
+				MintempC  float64 `json:"mintemp_c"`
+				Condition struct {
+					Code int `json:"code"`
+				} `json:"condition"`
+			} `json:"day"`
+		} `json:"forecastday"`
+	} `json:"forecast"`
+}
+
+// weatherAPICondition maps WeatherAPI.com condition codes onto the
+// response vocabulary.
+func weatherAPICondition(code int) string {
+	switch code {
+	case 1000:
+		return "clear"
+	case 1003:
+		return "partly-cloudy"
+	case 1006, 1009:
+		return "cloudy"
+	case 1030, 1135, 1147:
+		return "fog"
+	case 1063, 1150, 1153, 1180, 1183, 1186, 1189, 1192, 1195, 1240, 1243, 1246:
+		return "rain"
+	case 1066, 1114, 1117, 1210, 1213, 1216, 1219, 1222, 1225, 1255, 1258:
+		return "snow"
+	case 1069, 1072, 1168, 1171, 1198, 1201, 1204, 1207, 1237, 1249, 1252, 1261, 1264:
+		return "sleet"
+	case 1087, 1273, 1276, 1279, 1282:
+		return "thunderstorm"
+	}
+	return "unknown"
+}
+
+// fetch returns the combined current and forecast document for coord,
+// from the cache when another lookup fetched it recently.
+func (w weatherAPI) fetch(ctx context.Context, coord Coord) (weatherAPIResponse, error) {
+	var d weatherAPIResponse
+	key := fmt.Sprintf("weatherapi:%.2f,%.2f", coord.Lat, coord.Lon)
+	if b, ok := geocodes.store.get(key); ok && json.Unmarshal(b, &d) == nil {
+		return d, nil
+	}
+
+	resp, err := httpGet(ctx, baseURL("weatherAPI")+"/v1/forecast.json?key="+w.apiKey+
+		fmt.Sprintf("&q=%.2f,%.2f", coord.Lat, coord.Lon)+"&days="+strconv.Itoa(maxForecastDays))
+	if err != nil {
+		return d, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return d, fmt.Errorf("weatherAPI: %s", resp.Status)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&d); err != nil {
+		return d, err
+	}
+
+	if b, err := json.Marshal(d); err == nil {
+		geocodes.store.set(key, b, weatherAPITTL)
+	}
+	return d, nil
+}
+
+func (w weatherAPI) temperature(ctx context.Context, loc Location) (float64, error) {
+	var coord Coord
+	if loc.Coord != nil {
+		coord = *loc.Coord
+	} else {
+		var err error
+		if coord, err = locateCoord(ctx, loc.query()); err != nil {
+			return 0, err
+		}
+	}
+
+	celsius, err := w.temperatureAt(ctx, coord)
+	if err != nil {
+		return 0, err
+	}
+
+	logReading(ctx, "weatherAPI", loc, celsius)
+	return celsius, nil
+}
+
+func (w weatherAPI) temperatureAt(ctx context.Context, coord Coord) (float64, error) {
+	d, err := w.fetch(ctx, coord)
+	if err != nil {
+		return 0, err
+	}
+	if d.Current.TempC == nil {
+		return 0, fmt.Errorf("weatherAPI: no temperature")
+	}
+	return *d.Current.TempC, nil
+}
+
+func (w weatherAPI) observe(ctx context.Context, city string) (Observation, error) {
+	coord, err := locateCoord(ctx, city)
+	if err != nil {
+		return Observation{}, err
+	}
+
+	d, err := w.fetch(ctx, coord)
+	if err != nil {
+		return Observation{}, err
+	}
+
+	c := d.Current
+	o := Observation{
+		Temp:          c.TempC,
+		Humidity:      c.Humidity,
+		WindDirection: c.WindDegree,
+		Pressure:      c.PressureMb,
+		CloudCover:    c.Cloud,
+		Visibility:    c.VisKm,
+		UVIndex:       c.UV,
+	}
+	if c.WindKph != nil {
+		o.WindSpeed = ptr(*c.WindKph / 3.6)
+	}
+	return o, nil
+}
+
+func (w weatherAPI) forecast(ctx context.Context, city string, days int) ([]dailyForecast, error) {
+	coord, err := locateCoord(ctx, city)
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := w.fetch(ctx, coord)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []dailyForecast
+	for _, f := range d.Forecast.Forecastday {
+		if len(out) == days {
+			break
+		}
+		out = append(out, dailyForecast{
+			date:      f.Date,
+			high:      f.Day.MaxtempC,
+			low:       f.Day.MintempC,
+			condition: weatherAPICondition(f.Day.Condition.Code),
+		})
+	}
+	return out, nil
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The server side of RFC 6455, enough for JSON text messages: no
+// extensions or subprotocols.
+
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xa
+
+	wsGUID           = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	maxWSMessageSize = 64 << 10
+	wsWriteTimeout   = 10 * time.Second
+)
+
+// Close codes from RFC 6455 section 7.4.1.
+const (
+	wsNormalClosure   = 1000
+	wsGoingAway       = 1001
+	wsProtocolError   = 1002
+	wsUnsupportedData = 1003
+	wsTooBig          = 1009
+)
+
+var (
+	errWSClosed = errors.New("websocket closed")
+	errWSTooBig = fmt.Errorf("message larger than %d bytes", maxWSMessageSize)
+)
+
+type wsConn struct {
+	conn net.Conn
+	br   *bufio.Reader
+
+	mu sync.Mutex // serializes writes
+}
+
+// headerHas reports whether the comma-separated header h lists token.
+func headerHas(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// upgradeWebSocket answers the opening handshake and takes over the
+// connection. On error a response has already been written.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != http.MethodGet || !headerHas(r.Header, "Connection", "upgrade") || !headerHas(r.Header, "Upgrade", "websocket") || key == "" {
+		w.Header().Set("Upgrade", "websocket")
+		http.Error(w, "want a WebSocket upgrade", http.StatusUpgradeRequired)
+		return nil, fmt.Errorf("not a websocket handshake")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported WebSocket version", http.StatusBadRequest)
+		return nil, fmt.Errorf("websocket version %q", r.Header.Get("Sec-WebSocket-Version"))
+	}
+
+	conn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, err
+	}
+	// The server's read and write timeouts are meant for requests, not
+	// for connections that stay open.
+	conn.SetDeadline(time.Time{})
+
+	sum := sha1.Sum([]byte(key + wsGUID))
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", base64.StdEncoding.EncodeToString(sum[:]))
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{conn: conn, br: rw.Reader}, nil
+}
+
+// writeFrame sends one unmasked, unfragmented frame.
+func (c *wsConn) writeFrame(op byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	header := []byte{0x80 | op}
+	switch n := len(payload); {
+	case n < 126:
+		header = append(header, byte(n))
+	case n <= 0xffff:
+		header = append(header, 126, byte(n>>8), byte(n))
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	if _, err := c.conn.Write(append(header, payload...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c *wsConn) writeJSON(v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.writeFrame(wsText, b)
+}
+
+// close sends a close frame and closes the connection.
+func (c *wsConn) close(code int, reason string) {
+	if len(reason) > 123 {
+		reason = reason[:123] // control frames carry at most 125 bytes
+	}
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	c.writeFrame(wsClose, append(payload, reason...))
+	c.conn.Close()
+}
+
+// readFrame reads one frame, unmasking its payload.
+func (c *wsConn) readFrame() (fin bool, op byte, payload []byte, err error) {
+	var h [2]byte
+	if _, err = io.ReadFull(c.br, h[:]); err != nil {
+		return
+	}
+	fin, op = h[0]&0x80 != 0, h[0]&0x0f
+	if h[0]&0x70 != 0 {
+		return fin, op, nil, fmt.Errorf("reserved bits set")
+	}
+	if h[1]&0x80 == 0 {
+		return fin, op, nil, fmt.Errorf("unmasked client frame")
+	}
+
+	n := uint64(h[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if op >= wsClose && (n > 125 || !fin) {
+		return fin, op, nil, fmt.Errorf("invalid control frame")
+	}
+	if n > maxWSMessageSize {
+		return fin, op, nil, errWSTooBig
+	}
+
+	var mask [4]byte
+	if _, err = io.ReadFull(c.br, mask[:]); err != nil {
+		return
+	}
+	payload = make([]byte, n)
+	if _, err = io.ReadFull(c.br, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, op, payload, nil
+}
+
+// readMessage returns the next text message, answering pings and
+// reassembling fragments on the way. It returns errWSClosed once the
+// client has closed the connection, after completing the close
+// handshake; on protocol errors it closes the connection itself.
+func (c *wsConn) readMessage() ([]byte, error) {
+	var msg []byte
+	fragmented := false
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			code := wsProtocolError
+			if err == errWSTooBig {
+				code = wsTooBig
+			}
+			if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+				c.close(code, err.Error())
+			}
+			return nil, err
+		}
+
+		switch op {
+		case wsPing:
+			if err := c.writeFrame(wsPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case wsPong:
+			continue
+		case wsClose:
+			c.close(wsNormalClosure, "")
+			return nil, errWSClosed
+		case wsBinary:
+			c.close(wsUnsupportedData, "text messages only")
+			return nil, fmt.Errorf("binary message")
+		case wsText:
+			if fragmented {
+				c.close(wsProtocolError, "expected a continuation frame")
+				return nil, fmt.Errorf("interleaved message")
+			}
+		case wsContinuation:
+			if !fragmented {
+				c.close(wsProtocolError, "unexpected continuation frame")
+				return nil, fmt.Errorf("stray continuation frame")
+			}
+		default:
+			c.close(wsProtocolError, "unknown opcode")
+			return nil, fmt.Errorf("opcode %#x", op)
+		}
+
+		if len(msg)+len(payload) > maxWSMessageSize {
+			c.close(wsTooBig, errWSTooBig.Error())
+			return nil, errWSTooBig
+		}
+		msg = append(msg, payload...)
+		if fin {
+			return msg, nil
+		}
+		fragmented = true
+	}
+}